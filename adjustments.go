@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ibkr-report/broker"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Adjustment types
+const (
+	addTrade        = "addTrade"
+	removeTrade     = "removeTrade"
+	overrideISIN    = "isin"
+	returnOfCapital = "returnOfCapital"
+	sourceCountry   = "sourceCountry"
+	foreignTax      = "foreignTax"
+)
+
+// adjustmentOrder is the order adjustment types are applied in. ISINs are set first, so that other adjustments may refer to them
+// Source countries are set last, also on the trades and taxes added
+var adjustmentOrder = []string{overrideISIN, removeTrade, addTrade, foreignTax, returnOfCapital, sourceCountry}
+
+// adjustmentsBroker names the statement holding the trades and taxes added by adjustments
+const adjustmentsBroker = "Adjustments"
+
+// adjustment is a correction of the statements maintained by the user, for events never read correctly
+// The instrument is identified by ISIN, with or without the check digit, or by the broker's symbol if it has no ISIN
+type adjustment struct {
+	Type     string  `json:"type"`
+	ISIN     string  `json:"isin"`
+	Symbol   string  `json:"symbol"`
+	Date     string  `json:"date"`
+	Quantity float64 `json:"quantity"`
+	Price    float64 `json:"price"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Country  string  `json:"country"`
+	// Note is the reason for the adjustment, listed in the explanation
+	Note string `json:"note"`
+
+	date   time.Time
+	origin broker.Origin
+}
+
+// instrument returns the instrument the adjustment applies to. Instruments without an ISIN are identified by symbol
+func (a adjustment) instrument() broker.Instrument {
+	if a.ISIN != "" {
+		return broker.ISIN(a.ISIN)
+	}
+	return broker.Instrument{ID: a.Symbol, Scheme: broker.SchemeSymbol}
+}
+
+// matches reports whether an instrument read from a statement is the one adjusted. The source country is not compared
+func (a adjustment) matches(ins broker.Instrument) bool {
+	return ins.ID == a.instrument().ID
+}
+
+func (a adjustment) String() string {
+	id := a.ISIN
+	if id == "" {
+		id = a.Symbol
+	}
+	var s string
+	switch a.Type {
+	case addTrade:
+		s = fmt.Sprintf("Dodana transakcija %s %s, količina %s po %s %s", id, a.Date, formatFloat(a.Quantity), formatFloat(a.Price), a.Currency)
+	case removeTrade:
+		s = fmt.Sprintf("Uklonjena transakcija %s %s, količina %s", id, a.Date, formatFloat(a.Quantity))
+	case overrideISIN:
+		s = fmt.Sprintf("ISIN %s za simbol %s", a.ISIN, a.Symbol)
+	case returnOfCapital:
+		amount := "cijela isplata"
+		if a.Amount != 0 {
+			amount = formatFloat(a.Amount)
+		}
+		s = fmt.Sprintf("Povrat kapitala %s %s, %s", id, a.Date, amount)
+	case sourceCountry:
+		s = fmt.Sprintf("Država izvora %s za %s", a.Country, id)
+	case foreignTax:
+		s = fmt.Sprintf("Plaćeni porez u inozemstvu %s %s, %s %s", id, a.Date, formatFloat(a.Amount), a.Currency)
+	}
+	if a.Note != "" {
+		s += " (" + a.Note + ")"
+	}
+	return s
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// validate checks the fields required by the adjustment type, and normalizes them
+func (a *adjustment) validate() error {
+	if !slices.Contains(adjustmentOrder, a.Type) {
+		return fmt.Errorf("unknown adjustment type %q", a.Type)
+	}
+
+	a.ISIN, a.Symbol = strings.ToUpper(strings.TrimSpace(a.ISIN)), strings.TrimSpace(a.Symbol)
+	if a.ISIN != "" {
+		if len(a.ISIN) != 11 && len(a.ISIN) != 12 {
+			return fmt.Errorf("invalid ISIN %q", a.ISIN)
+		}
+		// Instruments are identified by ISIN without the check digit
+		a.ISIN = a.ISIN[:11]
+	}
+	if a.ISIN == "" && a.Symbol == "" {
+		return errors.New("ISIN or symbol required")
+	}
+	if a.Type == overrideISIN && (a.ISIN == "" || a.Symbol == "") {
+		return errors.New("ISIN and symbol required")
+	}
+
+	if a.Type != overrideISIN && a.Type != sourceCountry {
+		d, err := time.Parse("2006-01-02", a.Date)
+		if err != nil {
+			return fmt.Errorf("invalid date %q", a.Date)
+		}
+		a.date = d
+	}
+	a.Currency, a.Country = strings.ToUpper(a.Currency), strings.ToUpper(a.Country)
+
+	switch a.Type {
+	case addTrade:
+		if a.Quantity == 0 || a.Price < 0 || len(a.Currency) != 3 {
+			return errors.New("quantity, price and currency required")
+		}
+	case removeTrade:
+		if a.Quantity == 0 {
+			return errors.New("quantity required")
+		}
+	case returnOfCapital:
+		if a.Amount < 0 {
+			return fmt.Errorf("invalid amount %v", a.Amount)
+		}
+	case sourceCountry:
+		if len(a.Country) != 2 {
+			return fmt.Errorf("invalid country %q", a.Country)
+		}
+	case foreignTax:
+		if a.Amount == 0 || len(a.Currency) != 3 {
+			return errors.New("amount and currency required")
+		}
+	}
+	return nil
+}
+
+// readAdjustments reads a JSON array of adjustments. Each adjustment keeps the line it starts on, to be traced back to
+// The checksum of the file is returned to document it with the statements
+func readAdjustments(filename string) ([]adjustment, string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return nil, "", fmt.Errorf("%s: expected an array of adjustments", filename)
+	}
+
+	var adjs []adjustment
+	var errs []error
+	for dec.More() {
+		// The offset is at the end of the previous value. The adjustment starts at the next brace
+		offset := int(dec.InputOffset())
+		if i := bytes.IndexByte(data[offset:], '{'); i >= 0 {
+			offset += i
+		}
+		origin := broker.Origin{File: filename, Line: 1 + bytes.Count(data[:offset], []byte("\n"))}
+
+		var a adjustment
+		if err := dec.Decode(&a); err != nil {
+			return nil, "", fmt.Errorf("%s: %w", origin, err)
+		}
+		a.origin = origin
+		if err := a.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", origin, err))
+			continue
+		}
+		adjs = append(adjs, a)
+	}
+	return adjs, hex.EncodeToString(sum[:]), errors.Join(errs...)
+}
+
+// adjust applies adjustments to the statements. Trades and taxes added are returned in a statement of their own
+// Adjustments matching nothing in the statements are diagnosed as errors
+func adjust(stmts []*broker.Statement, adjs []adjustment, filename, checksum string) ([]*broker.Statement, []broker.Diagnostic) {
+	added := &broker.Statement{Broker: adjustmentsBroker, Filename: filename, Checksum: checksum}
+	stmts = append(stmts, added)
+
+	var diags []broker.Diagnostic
+	for _, typ := range adjustmentOrder {
+		for _, a := range adjs {
+			if a.Type != typ {
+				continue
+			}
+			if !a.apply(stmts, added) {
+				diags = append(diags, broker.Diagnostic{Origin: a.origin, Section: "Adjustments", Severity: broker.Error, Message: "Adjustment matches nothing in the statements: " + a.String()})
+			}
+		}
+	}
+	return stmts, diags
+}
+
+// apply applies the adjustment to the statements, adding trades and taxes to the added statement
+// It returns false if the adjustment matched nothing
+func (a adjustment) apply(stmts []*broker.Statement, added *broker.Statement) bool {
+	switch a.Type {
+	case addTrade:
+		added.Trades = append(added.Trades, broker.Trade{
+			Instrument: a.instrument(),
+			Category:   broker.Equity,
+			Time:       a.date,
+			Currency:   a.Currency,
+			Quantity:   a.Quantity,
+			Price:      a.Price,
+			Origin:     a.origin,
+		})
+		return true
+	case foreignTax:
+		added.Tax = append(added.Tax, broker.Tx{
+			Instrument: a.instrument(),
+			Category:   broker.Equity,
+			Currency:   a.Currency,
+			// Tax withheld is negative in the statements
+			Amount: -math.Abs(a.Amount),
+			Year:   a.date.Year(),
+			Date:   a.date,
+			Origin: a.origin,
+		})
+		return true
+	}
+
+	found := false
+	for _, stmt := range stmts {
+		switch a.Type {
+		case removeTrade:
+			stmt.Trades = slices.DeleteFunc(stmt.Trades, func(t broker.Trade) bool {
+				match := a.matches(t.Instrument) && sameDay(t.Time, a.date) && math.Abs(t.Quantity-a.Quantity) < 1e-9
+				found = found || match
+				return match
+			})
+		case returnOfCapital:
+			found = a.reclassify(stmt) || found
+		case overrideISIN, sourceCountry:
+			found = a.setInstrument(stmt) || found
+		}
+	}
+	return found
+}
+
+// reclassify moves a dividend paid on the adjustment date from income to return of capital
+// Without an amount, the whole payment is return of capital
+func (a adjustment) reclassify(stmt *broker.Statement) bool {
+	for i, tx := range stmt.FixedIncome {
+		if !a.matches(tx.Instrument) || !sameDay(tx.Date, a.date) || tx.Amount <= 0 {
+			continue
+		}
+		roc := tx
+		if a.Amount != 0 && a.Amount < tx.Amount {
+			roc.Amount = a.Amount
+			stmt.FixedIncome[i].Amount -= a.Amount
+		} else {
+			stmt.FixedIncome = slices.Delete(stmt.FixedIncome, i, i+1)
+		}
+		stmt.ReturnOfCapital = append(stmt.ReturnOfCapital, roc)
+		return true
+	}
+	return false
+}
+
+// setInstrument sets the ISIN of instruments identified by symbol, or the source country of an instrument, wherever it is used
+func (a adjustment) setInstrument(stmt *broker.Statement) bool {
+	found := false
+	set := func(ins *broker.Instrument) {
+		switch {
+		case a.Type == overrideISIN && ins.Scheme == broker.SchemeSymbol && ins.ID == a.Symbol:
+			*ins = broker.ISIN(a.ISIN)
+		case a.Type == sourceCountry && a.matches(*ins):
+			ins.Country = a.Country
+		default:
+			return
+		}
+		found = true
+	}
+
+	for i := range stmt.Trades {
+		set(&stmt.Trades[i].Instrument)
+	}
+	for _, txs := range [][]broker.Tx{stmt.FixedIncome, stmt.Tax, stmt.Fees, stmt.ReturnOfCapital} {
+		for i := range txs {
+			set(&txs[i].Instrument)
+		}
+	}
+	for i := range stmt.Transfers {
+		set(&stmt.Transfers[i].Instrument)
+	}
+	for i := range stmt.Prices {
+		set(&stmt.Prices[i].Instrument)
+	}
+	for i := range stmt.Realized {
+		set(&stmt.Realized[i].Instrument)
+	}
+	return found
+}
+
+func sameDay(a, b time.Time) bool {
+	ya, ma, da := a.Date()
+	yb, mb, db := b.Date()
+	return ya == yb && ma == mb && da == db
+}
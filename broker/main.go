@@ -1,42 +1,273 @@
 package broker
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 )
 
 var ErrNotRecognized = errors.New("statement not recognized")
 
+// Asset categories shared by all broker readers
+const (
+	Equity = "Equity"
+	// Bond trades use face value as Quantity and a Price per unit of face value.
+	// Redemptions at maturity or call are reported as sales at the redemption price
+	Bond   = "Bond"
+	Crypto = "Crypto"
+)
+
+// Instrument identifier schemes
+const (
+	// SchemeISIN identifiers are stored without the ISIN check digit
+	SchemeISIN   = "ISIN"
+	SchemeFIGI   = "FIGI"
+	SchemeCrypto = "Crypto"
+	// SchemeSymbol is a broker-specific ticker symbol, used when nothing better is known
+	SchemeSymbol = "Symbol"
+)
+
+// Instrument identifies a traded asset across brokers and statements
+type Instrument struct {
+	// ID is unique within a Scheme
+	ID, Scheme string
+	// Country is the source country of income from the instrument, as an ISO 3166 alpha-2 code
+	// Empty if unknown or not applicable (e.g. crypto)
+	Country string
+}
+
+// ISIN creates an Instrument from an ISIN code. The source country is derived from the ISIN country prefix
+func ISIN(isin string) Instrument {
+	i := Instrument{ID: isin, Scheme: SchemeISIN}
+	if len(isin) >= 2 && isLetter(isin[0]) && isLetter(isin[1]) {
+		i.Country = isin[:2]
+	}
+	return i
+}
+
+// CryptoAsset creates an Instrument from a crypto asset ticker. Crypto assets have no source country
+func CryptoAsset(ticker string) Instrument {
+	return Instrument{ID: ticker, Scheme: SchemeCrypto}
+}
+
+func isLetter(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
+
+// Origin locates the statement row a record was read from
+type Origin struct {
+	File string
+	// Line is the line number in the statement file, if known
+	Line int
+}
+
+func (o Origin) String() string {
+	if o.Line == 0 {
+		return o.File
+	}
+	return fmt.Sprintf("%s:%d", o.File, o.Line)
+}
+
 // Tx is a catch-all transaction type
 // in this version, it can represent all transaction types except for trades, which need to track quotes at a specific time (Year is not enough)
+// Bond coupons and accrued interest are FixedIncome with a Bond category. Accrued interest paid on purchase has a negative Amount
 type Tx struct {
-	ISIN               string
+	Instrument         Instrument
 	Category, Currency string
 	Amount             float64
 	Year               int
+	// Date is the payment date, if known. Dividends filed per payment need it
+	Date   time.Time
+	Origin Origin
 }
 
 type Trade struct {
-	ISIN               string
+	Instrument         Instrument
 	Time               time.Time
 	Category, Currency string
 	Quantity, Price    float64
+	Origin             Origin
+}
+
+// Conversion is a currency exchange at a broker. Both Sold and Bought amounts are positive
+type Conversion struct {
+	Time         time.Time
+	From, To     string
+	Sold, Bought float64
+	Origin       Origin
+}
+
+// Transfer is a position moved in or out of an account. Quantity is positive for transfers in and negative for transfers out
+// Transfers are not sales. The position keeps the acquisition date and cost of the lots it was bought in
+type Transfer struct {
+	Instrument Instrument
+	Time       time.Time
+	Quantity   float64
+	Origin     Origin
+}
+
+// Realized is the profit or loss realized on an instrument in a statement's period, as calculated by the broker
+// It is used to check the profits matched from trades, not for the report
+type Realized struct {
+	Instrument Instrument
+	// Symbol is the broker's symbol for the instrument
+	Symbol   string
+	Currency string
+	Amount   float64
+	Origin   Origin
+}
+
+// Price is the last known price of an instrument at the end of a statement period
+// Currency is empty if not reported with the price, in which case it is the currency the instrument is traded in
+type Price struct {
+	Instrument Instrument
+	Currency   string
+	Price      float64
+	Date       time.Time
+}
+
+// Period is the date range a statement covers. Both days are included
+type Period struct {
+	From, To time.Time
+}
+
+func (p Period) IsZero() bool {
+	return p.From.IsZero() && p.To.IsZero()
+}
+
+// Contains reports if the other period is entirely within this one
+func (p Period) Contains(o Period) bool {
+	return !o.From.Before(p.From) && !o.To.After(p.To)
+}
+
+// Overlaps reports if the periods have at least one day in common
+func (p Period) Overlaps(o Period) bool {
+	return !o.From.After(p.To) && !o.To.Before(p.From)
+}
+
+func (p Period) String() string {
+	return p.From.Format("2006-01-02") + " - " + p.To.Format("2006-01-02")
+}
+
+// Severity ranks diagnostics. Errors are data that could not be read, warnings are data likely wrong or incomplete
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "Warning"
+	case Error:
+		return "Error"
+	default:
+		return "Info"
+	}
+}
+
+// Diagnostic is a problem found reading a statement or building the report from it
+type Diagnostic struct {
+	// Origin is the statement row the problem was found in. Line is zero for problems with a whole file, File is empty for problems with no single source
+	Origin   Origin
+	Section  string
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	s := d.Severity.String() + ":"
+	if d.Origin.File != "" {
+		s += " " + d.Origin.File
+		if d.Origin.Line > 0 {
+			s += ":" + strconv.Itoa(d.Origin.Line)
+		}
+	}
+	if d.Section != "" {
+		s += " [" + d.Section + "]"
+	}
+	return s + " " + d.Message
+}
+
+// Diagnostics collects the problems found reading statements and building the report. It is safe for concurrent use
+type Diagnostics struct {
+	mu   sync.Mutex
+	list []Diagnostic
+}
+
+func (d *Diagnostics) Add(ds ...Diagnostic) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.list = append(d.list, ds...)
+}
+
+// List returns the diagnostics ordered by file and line. Problems with no single source come first
+func (d *Diagnostics) List() []Diagnostic {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := append([]Diagnostic{}, d.list...)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Origin.File != list[j].Origin.File {
+			return list[i].Origin.File < list[j].Origin.File
+		}
+		return list[i].Origin.Line < list[j].Origin.Line
+	})
+	return list
+}
+
+// Count returns the number of diagnostics of a severity
+func (d *Diagnostics) Count(s Severity) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for _, diag := range d.list {
+		if diag.Severity == s {
+			n++
+		}
+	}
+	return n
 }
 
 // Statement is an envelope for all relevant broker data found in a single broker statement file
 type Statement struct {
-	Broker                 string
-	Filename               string
+	Broker   string
+	Filename string
+	// Checksum is the hex encoded SHA-256 of the statement file, kept to document the source of reported figures
+	Checksum string
+	// Account and Period identify the account and dates the statement covers, if known
+	Account                string
+	Period                 Period
 	Trades                 []Trade
 	FixedIncome, Tax, Fees []Tx
+	// ReturnOfCapital are distributions paying back part of the cost of an instrument. They are not income
+	ReturnOfCapital []Tx
+	Conversions     []Conversion
+	Transfers       []Transfer
+	Realized        []Realized
+	// Prices are the prices of open positions at the end of the statement period
+	Prices []Price
+	// Diagnostics are the problems found reading the statement
+	Diagnostics []Diagnostic
 }
 
 type StatementReader func(filename string) (*Statement, error)
 
 type Reader struct {
 	readers map[string][]StatementReader
-	done    map[string]struct{}
+	// done holds the checksums of files read, to skip copies of the same file
+	done map[string]struct{}
+	mu   sync.Mutex
 }
 
 func NewReader() *Reader {
@@ -52,18 +283,31 @@ func (r *Reader) Register(ext string, readers ...StatementReader) error {
 	return nil
 }
 
+// Read reads a statement file with the first reader recognizing it
+// Files with the same content as a file read before are skipped, returning a nil Statement
 func (r *Reader) Read(filename string) (*Statement, error) {
-	// Has this file been read before?
-	if _, ok := r.done[filename]; ok {
-		return nil, nil
-	}
 	readers, ok := r.readers[filepath.Ext(filename)]
 	if !ok {
 		return nil, ErrNotRecognized
 	}
+
+	sum, err := checksum(filename)
+	if err != nil {
+		return nil, err
+	}
+	// Has this file been read before?
+	r.mu.Lock()
+	_, ok = r.done[sum]
+	r.done[sum] = struct{}{}
+	r.mu.Unlock()
+	if ok {
+		return nil, nil
+	}
+
 	for _, read := range readers {
 		stmt, err := read(filename)
 		if err == nil {
+			stmt.Checksum = sum
 			return stmt, nil
 		}
 		if errors.Is(err, ErrNotRecognized) {
@@ -74,3 +318,18 @@ func (r *Reader) Read(filename string) (*Statement, error) {
 
 	return nil, ErrNotRecognized
 }
+
+// checksum returns the hex encoded SHA-256 of a file
+func checksum(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"ibkr-report/broker"
+	"ibkr-report/eporezna"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultConfig is the configuration file read if present and no other is given
+const defaultConfig = "ibkr-report.json"
+
+// config is the JSON configuration file. All fields are optional
+type config struct {
+	Taxpayer struct {
+		OIB          string `json:"oib"`
+		Name         string `json:"name"`
+		Municipality string `json:"municipality"`
+	} `json:"taxpayer"`
+	// Residence lists the periods of Croatian tax residence, with dates as YYYY-MM-DD. A period without an end is ongoing
+	Residence []struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	} `json:"residence"`
+	// Inputs are the directories and files to read statements from
+	Inputs    []string `json:"inputs"`
+	Formats   []string `json:"formats"`
+	FXTaxable bool     `json:"fxTaxable"`
+	Strict    bool     `json:"strict"`
+	// Brokers holds options per statement reader, by reader name
+	Brokers map[string]brokerOptions `json:"brokers"`
+	// Adjustments is the file of manual corrections to the statements
+	Adjustments string `json:"adjustments"`
+}
+
+// brokerOptions are the options of a statement reader
+type brokerOptions struct {
+	// Disabled skips the reader. Its files are not read
+	Disabled bool `json:"disabled"`
+}
+
+// readConfig reads a configuration file. Unknown fields are an error, to catch misspelled options
+// A missing file is not an error unless required
+func readConfig(filename string, required bool) (config, error) {
+	var c config
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("%s: %w", filename, err)
+	}
+	return c, nil
+}
+
+// withConfig fills in the settings not set with flags from the configuration file
+func (s *settings) withConfig(c config, fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if !set["oib"] {
+		s.oib = c.Taxpayer.OIB
+	}
+	if !set["name"] {
+		s.name = c.Taxpayer.Name
+	}
+	if !set["municipality"] {
+		s.municipality = c.Taxpayer.Municipality
+	}
+	if !set["format"] && len(c.Formats) > 0 {
+		s.formats = strings.Join(c.Formats, ",")
+	}
+	if !set["fx-taxable"] {
+		s.fxTaxable = c.FXTaxable
+	}
+	if !set["strict"] {
+		s.strict = c.Strict
+	}
+	if !set["adjustments"] {
+		s.adjustments = c.Adjustments
+	}
+
+	s.inputs = c.Inputs
+	if len(s.inputs) == 0 {
+		s.inputs = []string{"."}
+	}
+	s.brokers = c.Brokers
+
+	var errs []error
+	for _, r := range c.Residence {
+		from, err := time.Parse("2006-01-02", r.From)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid residence start %q", r.From))
+			continue
+		}
+		p := broker.Period{From: from}
+		if r.To != "" {
+			if p.To, err = time.Parse("2006-01-02", r.To); err != nil || p.To.Before(from) {
+				errs = append(errs, fmt.Errorf("invalid residence end %q", r.To))
+				continue
+			}
+		}
+		s.residence = append(s.residence, p)
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the settings before any statement is read
+func (s settings) validate() error {
+	var errs []error
+	for _, format := range strings.Split(s.formats, ",") {
+		if _, ok := reportWriters[strings.TrimSpace(format)]; !ok {
+			errs = append(errs, fmt.Errorf("unknown report format %q", format))
+		}
+	}
+	// Municipalities without known surtax rates are accepted. The tax due is calculated without surtax, with a diagnostic
+	if s.municipality != "" && !validMunicipality(s.municipality) {
+		errs = append(errs, fmt.Errorf("invalid municipality %q: expected a 5 digit tax administration code", s.municipality))
+	}
+	if s.oib != "" {
+		if err := eporezna.ValidOIB(s.oib); err != nil {
+			errs = append(errs, fmt.Errorf("invalid taxpayer: %w", err))
+		}
+		if s.municipality == "" || s.name == "" {
+			errs = append(errs, errors.New("invalid taxpayer: name and municipality are required to generate ePorezna forms"))
+		}
+	}
+	for _, input := range s.inputs {
+		if _, err := os.Stat(input); err != nil {
+			errs = append(errs, fmt.Errorf("invalid input: %w", err))
+		}
+	}
+	if s.adjustments != "" {
+		if _, err := os.Stat(s.adjustments); err != nil {
+			errs = append(errs, fmt.Errorf("invalid adjustments: %w", err))
+		}
+	}
+	for name := range s.brokers {
+		if _, ok := statementReaders[name]; !ok {
+			errs = append(errs, fmt.Errorf("unknown broker %q", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validMunicipality checks the format of a municipality's tax administration code
+func validMunicipality(code string) bool {
+	if len(code) != 5 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// readers returns the statement readers not disabled, in the order they are tried
+func (s settings) readers() []broker.StatementReader {
+	var readers []broker.StatementReader
+	for _, name := range readerOrder {
+		if !s.brokers[name].Disabled {
+			readers = append(readers, statementReaders[name])
+		}
+	}
+	return readers
+}
+
+// resident reports whether the taxpayer was a Croatian tax resident on a date. Without a date, any day of the year counts
+// Without residence periods configured, the taxpayer is always a resident
+func (s settings) resident(date time.Time, year int) bool {
+	if len(s.residence) == 0 {
+		return true
+	}
+	for _, p := range s.residence {
+		to := p.To
+		if to.IsZero() {
+			to = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+		}
+		if date.IsZero() {
+			if p.From.Year() <= year && year <= to.Year() {
+				return true
+			}
+			continue
+		}
+		if !date.Before(p.From) && !date.After(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// withResidence drops the income, tax and fees from outside the periods of Croatian tax residence
+// The settings are kept, so that profits from trades matched again are filtered too
+func (l *ledger) withResidence(s settings) {
+	l.residence = s
+	filter := func(pls []pl) []pl {
+		var out []pl
+		for _, p := range pls {
+			if s.resident(p.date, p.year) {
+				out = append(out, p)
+			}
+		}
+		return out
+	}
+	l.profits, l.exempt, l.tax, l.fees, l.currencyGains = filter(l.profits), filter(l.exempt), filter(l.tax), filter(l.fees), filter(l.currencyGains)
+	// Fees are deductible only if paid while resident, like the fee details
+	l.deductible = make(map[int]float64)
+	for _, fee := range l.fees {
+		l.deductible[fee.year] += fee.amount
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"ibkr-report/broker"
+	"sort"
+	"strconv"
+)
+
+// coverageGaps finds periods with no statements between the first and last statement of each account
+func coverageGaps(stmts []*broker.Statement) map[string][]broker.Period {
+	byAccount := make(map[string][]broker.Period)
+	for _, stmt := range stmts {
+		if !stmt.Period.IsZero() {
+			byAccount[stmt.Account] = append(byAccount[stmt.Account], stmt.Period)
+		}
+	}
+
+	gaps := make(map[string][]broker.Period)
+	for account, periods := range byAccount {
+		sort.Slice(periods, func(i, j int) bool {
+			return periods[i].From.Before(periods[j].From)
+		})
+		covered := periods[0].To
+		for _, p := range periods[1:] {
+			if next := covered.AddDate(0, 0, 1); p.From.After(next) {
+				gaps[account] = append(gaps[account], broker.Period{From: next, To: p.From.AddDate(0, 0, -1)})
+			}
+			if p.To.After(covered) {
+				covered = p.To
+			}
+		}
+	}
+	return gaps
+}
+
+// coverageWarnings warns about statements likely missing: gaps in the periods covered for an account,
+// and sales of more than was bought, naming the period the purchases were likely made in
+func coverageWarnings(stmts []*broker.Statement, unmatched []broker.Trade) []broker.Diagnostic {
+	gaps := coverageGaps(stmts)
+	var warnings []broker.Diagnostic
+	for _, account := range sortedKeys(gaps) {
+		for _, gap := range gaps[account] {
+			warnings = append(warnings, broker.Diagnostic{Severity: broker.Warning, Message: fmt.Sprintf("No statements for account %s from %s", accountName(account), gap)})
+		}
+	}
+
+	statements := make(map[string]*broker.Statement, len(stmts))
+	for _, stmt := range stmts {
+		statements[stmt.Filename] = stmt
+	}
+	sort.Slice(unmatched, func(i, j int) bool {
+		return unmatched[i].Time.Before(unmatched[j].Time)
+	})
+	for _, sale := range unmatched {
+		w := broker.Diagnostic{Origin: sale.Origin, Section: "Trades", Severity: broker.Warning, Message: fmt.Sprintf("Sale of %s on %s exceeds known holdings by %s",
+			sale.Instrument.ID, sale.Time.Format("2006-01-02"), strconv.FormatFloat(-sale.Quantity, 'f', -1, 64))}
+		if stmt, ok := statements[sale.Origin.File]; ok {
+			w.Message += ". " + missingBefore(stmt.Account, sale, gaps[stmt.Account], stmts)
+		}
+		warnings = append(warnings, w)
+	}
+	return warnings
+}
+
+// missingBefore names the period a sold quantity was likely bought in: the last gap in coverage before the sale,
+// or the time before the account's first statement
+func missingBefore(account string, sale broker.Trade, gaps []broker.Period, stmts []*broker.Statement) string {
+	for i := len(gaps) - 1; i >= 0; i-- {
+		if gaps[i].To.Before(sale.Time) {
+			return fmt.Sprintf("The statement for %s is likely missing", gaps[i])
+		}
+	}
+
+	var first broker.Period
+	for _, stmt := range stmts {
+		if stmt.Account == account && !stmt.Period.IsZero() && (first.IsZero() || stmt.Period.From.Before(first.From)) {
+			first = stmt.Period
+		}
+	}
+	if first.IsZero() {
+		return "A statement with the purchase is likely missing"
+	}
+	return fmt.Sprintf("A statement before %s is likely missing", first.From.Format("2006-01-02"))
+}
+
+func accountName(account string) string {
+	if account == "" {
+		return "(unknown)"
+	}
+	return account
+}
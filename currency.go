@@ -0,0 +1,77 @@
+package main
+
+import (
+	"ibkr-report/broker"
+	"ibkr-report/fx"
+	"math"
+	"sort"
+	"time"
+)
+
+// cashLot is an amount of foreign currency acquired in a single conversion
+type cashLot struct {
+	time time.Time
+	// amount left in the foreign currency
+	amount float64
+	// cost is the price of a single unit of the foreign currency, in the Croatian currency at acquisition time
+	cost float64
+}
+
+// currencyGains tracks foreign cash balances as lots and returns the gains realized on converting them
+// Lots are acquired by converting to a foreign currency and disposed of on a FIFO basis by converting from it
+// Foreign cash with an unknown acquisition (e.g. proceeds from sales) is assumed to have no currency gain
+func currencyGains(cs []broker.Conversion, r fx.DailyRater) []pl {
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].Time.Before(cs[j].Time)
+	})
+
+	lots := make(map[string][]cashLot)
+	var pls []pl
+	for _, c := range cs {
+		base := fx.Base(c.Time.Year())
+		if c.From == c.To {
+			continue
+		}
+
+		// Value of the conversion in the Croatian currency
+		value := c.Sold * r.RateOn(c.From, c.Time)
+		if c.To == base {
+			value = c.Bought
+		} else if c.From == base {
+			value = c.Sold
+		}
+
+		if c.From != base {
+			var gain float64
+			lots[c.From], gain = disposeCash(lots[c.From], c.Sold, value/c.Sold, c.Time)
+			pls = append(pls, pl{amount: gain, year: c.Time.Year(), source: c.From, kind: capitalGain, date: c.Time, origins: []broker.Origin{c.Origin}})
+		}
+
+		if c.To != base {
+			lots[c.To] = append(lots[c.To], cashLot{time: c.Time, amount: c.Bought, cost: value / c.Bought})
+		}
+	}
+
+	return pls
+}
+
+// disposeCash consumes lots for the sold amount at a given unit price, returning remaining lots and the realized gain
+func disposeCash(lots []cashLot, sold, price float64, t time.Time) ([]cashLot, float64) {
+	var gain float64
+	for len(lots) > 0 && sold > 0 {
+		qty := math.Min(sold, lots[0].amount)
+		cost := lots[0].cost
+		if lots[0].time.Year() < 2023 && t.Year() >= 2023 {
+			cost /= fx.HRKPerEUR
+		}
+
+		gain += qty * (price - cost)
+		sold -= qty
+		lots[0].amount -= qty
+		if lots[0].amount <= 0 {
+			lots = lots[1:]
+		}
+	}
+
+	return lots, gain
+}
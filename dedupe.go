@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"ibkr-report/broker"
+	"sort"
+	"strings"
+	"time"
+)
+
+// deduplicate drops statements covered entirely by another statement of the same account, such as monthly statements of a year also read from a yearly statement
+// Statements partially overlapping another, or sharing identical trades with it, cannot be deduplicated safely and are reported as an error
+// It returns the statements to use and the files dropped, mapped to the files covering them
+func deduplicate(stmts []*broker.Statement) ([]*broker.Statement, map[string]string, error) {
+	// Longer periods first, so that a statement is only compared to the ones that may cover it
+	sort.Slice(stmts, func(i, j int) bool {
+		li, lj := stmts[i].Period.To.Sub(stmts[i].Period.From), stmts[j].Period.To.Sub(stmts[j].Period.From)
+		if li != lj {
+			return li > lj
+		}
+		return stmts[i].Filename < stmts[j].Filename
+	})
+
+	var kept []*broker.Statement
+	dropped := make(map[string]string)
+	var conflicts []string
+	for _, stmt := range stmts {
+		covered := false
+		for _, k := range kept {
+			if stmt.Period.IsZero() || k.Period.IsZero() || k.Account != stmt.Account || !k.Period.Overlaps(stmt.Period) {
+				continue
+			}
+			if k.Period.Contains(stmt.Period) {
+				dropped[stmt.Filename] = k.Filename
+				covered = true
+				break
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s) and %s (%s) overlap", k.Filename, k.Period, stmt.Filename, stmt.Period))
+		}
+		if !covered {
+			kept = append(kept, stmt)
+		}
+	}
+
+	conflicts = append(conflicts, duplicateTrades(kept)...)
+	if len(conflicts) > 0 {
+		return kept, dropped, fmt.Errorf("statements with duplicate data, remove or split them:\n%s", strings.Join(conflicts, "\n"))
+	}
+	return kept, dropped, nil
+}
+
+// duplicateTrades finds statements sharing identical trades. Trades match on instrument, time, quantity and price
+func duplicateTrades(stmts []*broker.Statement) []string {
+	type key struct {
+		instrument      broker.Instrument
+		time            time.Time
+		quantity, price float64
+	}
+	files := make(map[key]string)
+	shared := make(map[[2]string]int)
+	for _, stmt := range stmts {
+		// Identical trades within a statement are separate executions
+		seen := make(map[key]struct{})
+		for _, t := range stmt.Trades {
+			k := key{t.Instrument, t.Time, t.Quantity, t.Price}
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			if file, ok := files[k]; ok {
+				shared[[2]string{file, stmt.Filename}]++
+				continue
+			}
+			files[k] = stmt.Filename
+		}
+	}
+
+	var conflicts []string
+	for pair, n := range shared {
+		conflicts = append(conflicts, fmt.Sprintf("%s and %s have %d identical trades", pair[0], pair[1], n))
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// collect reads all statements from a channel
+func collect(statements <-chan *broker.Statement) []*broker.Statement {
+	var out []*broker.Statement
+	for stmt := range statements {
+		out = append(out, stmt)
+	}
+	return out
+}
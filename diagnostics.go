@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"ibkr-report/broker"
+	"io"
+)
+
+// printDiagnostics lists the problems found reading the statements and building the report, followed by their count
+func printDiagnostics(w io.Writer, diags *broker.Diagnostics) error {
+	for _, d := range diags.List() {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "Diagnostics: %d errors, %d warnings\n", diags.Count(broker.Error), diags.Count(broker.Warning))
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"ibkr-report/fx"
+	"ibkr-report/pdf"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// pdfWriter writes the report as a paginated PDF document, to be kept as Tax documentation
+// It lists the yearly summary, every taxable event with the exchange rate used, and the statement files the figures come from
+type pdfWriter struct{}
+
+func (pdfWriter) write(w io.Writer, r report) error {
+	doc := pdf.New("Porezno izvješće")
+
+	doc.Heading("Sažetak")
+	rows := r.toRows()
+	columns := make([]pdf.Column, len(rows[0]))
+	for i, title := range rows[0] {
+		// Same alignment as the text report
+		columns[i] = pdf.Column{Title: title, Right: i == 3 || i >= 5}
+	}
+	doc.Table(columns, rows[1:])
+
+	files := make(map[string]string)
+	fileYears := make(map[string][]string)
+	for _, y := range r.sorted() {
+		doc.Heading(fmt.Sprintf("%d (%s)", y.year, y.currency))
+		doc.Text(fmt.Sprintf("Dobit %.2f, porez za uplatu %.2f, odbijene naknade %.2f", y.realizedPL, y.taxDue, y.deductible))
+		writeDetails(doc, y)
+
+		for file, sum := range y.checksums {
+			files[file] = sum
+			fileYears[file] = append(fileYears[file], strconv.Itoa(y.year))
+		}
+	}
+
+	doc.Heading("Izvodi")
+	var statements [][]string
+	for _, file := range sortedKeys(files) {
+		statements = append(statements, []string{file, strings.Join(fileYears[file], ", "), files[file]})
+	}
+	doc.Table([]pdf.Column{{Title: "Datoteka"}, {Title: "Godine"}, {Title: "SHA-256"}}, statements)
+
+	return doc.Write(w)
+}
+
+// writeDetails lists the events behind a year's figures and the exchange rates used
+func writeDetails(doc *pdf.Document, y *taxYear) {
+	date := func(p pl) string {
+		if p.date.IsZero() {
+			return ""
+		}
+		return p.date.Format("2006-01-02")
+	}
+	num := func(f float64, prec int) string {
+		return strconv.FormatFloat(f, 'f', prec, 64)
+	}
+
+	if d := y.details.disposals; len(d) > 0 {
+		doc.Subheading("Prodaje")
+		var rows [][]string
+		for _, p := range d {
+			taxable := "Da"
+			if !p.disposal.taxable {
+				taxable = "Ne"
+			}
+			rows = append(rows, []string{p.instrument.ID, num(p.disposal.quantity, -1), p.disposal.acquired.Format("2006-01-02"), date(p),
+				num(p.disposal.cost, 4), num(p.disposal.price, 4), p.currency, num(p.rate, 6), num(p.original, 2), num(p.amount, 2), taxable})
+		}
+		doc.Table([]pdf.Column{{Title: "Instrument"}, {Title: "Količina", Right: true}, {Title: "Datum kupnje"}, {Title: "Datum prodaje"},
+			{Title: "Nabavna cijena", Right: true}, {Title: "Prodajna cijena", Right: true}, {Title: "Valuta"}, {Title: "Tečaj", Right: true},
+			{Title: "Dobit u valuti", Right: true}, {Title: "Dobit", Right: true}, {Title: "Oporezivo"}}, rows)
+	}
+
+	if d := y.details.payments; len(d) > 0 {
+		doc.Subheading("Dividende i kamate")
+		var rows [][]string
+		for _, p := range d {
+			rows = append(rows, []string{date(p), kindNames[p.kind], p.instrument.ID, p.source, p.currency, num(p.original, 2), num(p.rate, 6), num(p.amount, 2)})
+		}
+		doc.Table([]pdf.Column{{Title: "Datum"}, {Title: "Vrsta"}, {Title: "Instrument"}, {Title: "Izvor prihoda"}, {Title: "Valuta"},
+			{Title: "Iznos u valuti", Right: true}, {Title: "Tečaj", Right: true}, {Title: "Iznos", Right: true}}, rows)
+	}
+
+	if d := y.details.taxes; len(d) > 0 {
+		doc.Subheading("Porez po odbitku")
+		var rows [][]string
+		for _, p := range d {
+			rows = append(rows, []string{date(p), p.instrument.ID, p.source, p.currency, num(math.Abs(p.original), 2), num(p.rate, 6), num(math.Abs(p.amount), 2)})
+		}
+		doc.Table([]pdf.Column{{Title: "Datum"}, {Title: "Instrument"}, {Title: "Izvor prihoda"}, {Title: "Valuta"},
+			{Title: "Iznos u valuti", Right: true}, {Title: "Tečaj", Right: true}, {Title: "Iznos", Right: true}}, rows)
+	}
+
+	if d := y.details.fees; len(d) > 0 {
+		doc.Subheading("Naknade")
+		var rows [][]string
+		for _, p := range d {
+			rows = append(rows, []string{p.currency, num(math.Abs(p.original), 2), num(p.rate, 6), num(math.Abs(p.amount), 2)})
+		}
+		doc.Table([]pdf.Column{{Title: "Valuta"}, {Title: "Iznos u valuti", Right: true}, {Title: "Tečaj", Right: true}, {Title: "Iznos", Right: true}}, rows)
+	}
+
+	if rates := y.rates(); len(rates) > 0 {
+		doc.Subheading("Tečajevi")
+		// Yearly figures are converted with the HNB middle rate on the last day of the year
+		source := fx.RateDate(y.year).Format("2006-01-02")
+		var rows [][]string
+		for _, rate := range rates {
+			rows = append(rows, []string{rate.Currency, num(rate.Rate, 6), source})
+		}
+		doc.Table([]pdf.Column{{Title: "Valuta"}, {Title: "Tečaj", Right: true}, {Title: "Datum tečaja (HNB)"}}, rows)
+	}
+}
@@ -0,0 +1,164 @@
+package eporezna
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// ForeignIncome is a single INO-DOH row of income received from a source country
+type ForeignIncome struct {
+	Country string
+	// Income is the gross income, TaxPaid the Tax paid at the source and Creditable the part of it credited against Croatian Tax
+	Income, TaxPaid, Creditable float64
+	// Payments are the underlying payments, listed as attachments
+	Payments []Payment
+}
+
+// Payment is an income payment from a single instrument, listed as an INO-DOH attachment
+type Payment struct {
+	Instrument, Currency string
+	// Amount and TaxPaid are in the original Currency, converted with the Rate
+	Amount, TaxPaid, Rate float64
+}
+
+// unofficial marks the INO-DOH XML document as not following the official schema
+const unofficial = " Neslužbeni oblik: nije u skladu sa shemom ePorezne. Podatke unesite u obrazac INO-DOH ručno "
+
+// INODOH writes an INO-DOH XML document with the foreign income received in a year, dated on the submission date
+// Amounts are in the Croatian currency of the year
+// The document uses element names of its own, not the official schema, and cannot be uploaded to ePorezna. It is marked as
+// unofficial, to keep the figures in a machine readable form for entering them in the form
+func INODOH(w io.Writer, t Taxpayer, year int, date time.Time, incomes []ForeignIncome) error {
+	if err := ValidOIB(t.OIB); err != nil {
+		return err
+	}
+	if len(incomes) == 0 {
+		return errors.New("no foreign income to report")
+	}
+
+	// Without the official namespace and schema version, the document does not claim to conform to the ePorezna schema
+	doc := inodoh{
+		Metapodaci: newMetapodaci("Prijava poreza na dohodak od inozemnih primitaka", t.Name, date, ""),
+	}
+	doc.Zaglavlje.Razdoblje.DatumOd = fmt.Sprintf("%d-01-01", year)
+	doc.Zaglavlje.Razdoblje.DatumDo = fmt.Sprintf("%d-12-31", year)
+	doc.Zaglavlje.Obveznik = inodohObveznik{OIB: t.OIB, ImePrezime: t.Name, SifraOpcine: t.Municipality}
+
+	for _, in := range incomes {
+		doc.Tijelo.Prihodi.Prihod = append(doc.Tijelo.Prihodi.Prihod, inodohPrihod{
+			Drzava:        in.Country,
+			IznosPrihoda:  amount(in.Income),
+			PlaceniPorez:  amount(in.TaxPaid),
+			PriznatiPorez: amount(in.Creditable),
+		})
+		doc.Tijelo.Ukupno.IznosPrihoda += amount(in.Income)
+		doc.Tijelo.Ukupno.PlaceniPorez += amount(in.TaxPaid)
+		doc.Tijelo.Ukupno.PriznatiPorez += amount(in.Creditable)
+
+		for _, p := range in.Payments {
+			doc.Prilozi.Prilog = append(doc.Prilozi.Prilog, inodohPrilog{
+				Drzava:       in.Country,
+				Instrument:   p.Instrument,
+				Valuta:       p.Currency,
+				Iznos:        amount(p.Amount),
+				PlaceniPorez: amount(p.TaxPaid),
+				Tecaj:        strconv.FormatFloat(p.Rate, 'f', 6, 64),
+			})
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.EncodeToken(xml.Comment(unofficial)); err != nil {
+		return err
+	}
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// PrintINODOH writes a printable version of the INO-DOH form, with the list of attachments
+func PrintINODOH(w io.Writer, t Taxpayer, year int, currency string, incomes []ForeignIncome) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	lines := []string{
+		"INO-DOH\t" + strconv.Itoa(year) + "\t",
+		"Obveznik\t" + t.Name + "\t",
+		"OIB\t" + t.OIB + "\t",
+		"Valuta\t" + currency + "\t",
+		"\t\t",
+		"Država\tPrihod\tPlaćeni porez\tPriznati porez\t",
+	}
+	for _, in := range incomes {
+		lines = append(lines, fmt.Sprintf("%s\t%.2f\t%.2f\t%.2f\t", in.Country, in.Income, in.TaxPaid, in.Creditable))
+	}
+
+	lines = append(lines, "\t\t", "Prilozi\t\t", "Država\tInstrument\tValuta\tIznos\tPlaćeni porez\tTečaj\t")
+	for _, in := range incomes {
+		for _, p := range in.Payments {
+			lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%.2f\t%.2f\t%.6f\t", in.Country, p.Instrument, p.Currency, p.Amount, p.TaxPaid, p.Rate))
+		}
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(tw, line); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+type inodoh struct {
+	XMLName    xml.Name   `xml:"ObrazacINODOH"`
+	Metapodaci metapodaci `xml:"Metapodaci"`
+	Zaglavlje  struct {
+		Razdoblje struct {
+			DatumOd string `xml:"DatumOd"`
+			DatumDo string `xml:"DatumDo"`
+		} `xml:"Razdoblje"`
+		Obveznik inodohObveznik `xml:"Obveznik"`
+	} `xml:"Zaglavlje"`
+	Tijelo struct {
+		Prihodi struct {
+			Prihod []inodohPrihod `xml:"Prihod"`
+		} `xml:"Prihodi"`
+		Ukupno struct {
+			IznosPrihoda  amount `xml:"IznosPrihoda"`
+			PlaceniPorez  amount `xml:"PlaceniPorez"`
+			PriznatiPorez amount `xml:"PriznatiPorez"`
+		} `xml:"Ukupno"`
+	} `xml:"Tijelo"`
+	Prilozi struct {
+		Prilog []inodohPrilog `xml:"Prilog"`
+	} `xml:"Prilozi"`
+}
+
+type inodohObveznik struct {
+	OIB         string `xml:"OIB"`
+	ImePrezime  string `xml:"ImePrezime"`
+	SifraOpcine string `xml:"SifraOpcine"`
+}
+
+type inodohPrihod struct {
+	Drzava        string `xml:"Drzava"`
+	IznosPrihoda  amount `xml:"IznosPrihoda"`
+	PlaceniPorez  amount `xml:"PlaceniPorez"`
+	PriznatiPorez amount `xml:"PriznatiPorez"`
+}
+
+type inodohPrilog struct {
+	Drzava       string `xml:"Drzava"`
+	Instrument   string `xml:"Instrument"`
+	Valuta       string `xml:"Valuta"`
+	Iznos        amount `xml:"Iznos"`
+	PlaceniPorez amount `xml:"PlaceniPorez"`
+	Tecaj        string `xml:"Tecaj"`
+}
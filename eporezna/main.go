@@ -0,0 +1,292 @@
+package eporezna
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Taxpayer is the person filing the forms
+type Taxpayer struct {
+	// OIB is the Croatian personal identification number
+	OIB  string
+	Name string
+	// Municipality is the tax administration code of the municipality of residence
+	Municipality string
+}
+
+// Income kinds reported in JOPPD, with their recipient label (oznaka stjecatelja) and receipt label (oznaka primitka)
+var (
+	Interest     = Label{Recipient: "1001", Receipt: "0000"}
+	Dividends    = Label{Recipient: "1004", Receipt: "0000"}
+	CapitalGains = Label{Recipient: "1011", Receipt: "0000"}
+)
+
+// Label is a pair of JOPPD page B labels describing the kind of income
+type Label struct {
+	Recipient, Receipt string
+}
+
+// Income is a single JOPPD page B row
+type Income struct {
+	Label
+	// From and To are the period the income was received in
+	From, To time.Time
+	// Amount is the taxable income
+	Amount float64
+	// IncomeTax and Surtax are the Tax due on the Amount
+	IncomeTax, Surtax float64
+}
+
+// submitterSelf is the JOPPD submitter label for a taxpayer reporting their own income
+const submitterSelf = "4"
+
+// ErrInvalidOIB is returned for OIB numbers failing the ISO 7064 MOD 11,10 check
+var ErrInvalidOIB = errors.New("invalid OIB")
+
+// ValidOIB checks the OIB control digit
+func ValidOIB(oib string) error {
+	if len(oib) != 11 {
+		return fmt.Errorf("%w: must have 11 digits", ErrInvalidOIB)
+	}
+
+	a := 10
+	for i := 0; i < 10; i++ {
+		d := int(oib[i] - '0')
+		if d < 0 || d > 9 {
+			return fmt.Errorf("%w: must contain digits only", ErrInvalidOIB)
+		}
+		a = (a + d) % 10
+		if a == 0 {
+			a = 10
+		}
+		a = a * 2 % 11
+	}
+
+	control := 11 - a
+	if control == 10 {
+		control = 0
+	}
+	if int(oib[10]-'0') != control {
+		return fmt.Errorf("%w: control digit mismatch", ErrInvalidOIB)
+	}
+	return nil
+}
+
+// JOPPD writes a JOPPD XML document for the taxpayer's capital income, dated on the submission date
+// The document follows the field layout of the JOPPD v1.1 form. It is not yet validated against the published schema, so check it in
+// ePorezna before submitting
+func JOPPD(w io.Writer, t Taxpayer, date time.Time, incomes []Income) error {
+	if err := ValidOIB(t.OIB); err != nil {
+		return err
+	}
+	if len(incomes) == 0 {
+		return errors.New("no income to report")
+	}
+
+	first, last := splitName(t.Name)
+	doc := joppd{
+		Xmlns:      "http://e-porezna.porezna-uprava.hr/sheme/zahtjevi/ObrazacJOPPD/v1-1",
+		Verzija:    "1.1",
+		Metapodaci: newMetapodaci("Izvješće o primicima, porezu na dohodak i prirezu te doprinosima za obvezna osiguranja", t.Name, date, "ObrazacJOPPD-v1-1"),
+		StranaA: joppdA{
+			DatumIzvjesca:  date.Format("2006-01-02"),
+			OznakaIzvjesca: fmt.Sprintf("%02d%03d", date.Year()%100, date.YearDay()),
+			VrstaIzvjesca:  "1",
+			PodnositeljIzvjesca: joppdPodnositelj{
+				Ime:     first,
+				Prezime: last,
+				OIB:     t.OIB,
+				Oznaka:  submitterSelf,
+			},
+			BrojOsoba:        1,
+			BrojRedaka:       len(incomes),
+			IzvjesceSastavio: joppdSastavio{Ime: first, Prezime: last},
+		},
+	}
+
+	for i, in := range incomes {
+		due := amount(in.IncomeTax + in.Surtax)
+		doc.StranaA.PredujamPoreza.P1 += due
+		if in.Label == CapitalGains {
+			doc.StranaA.PredujamPoreza.P3 += due
+		} else {
+			doc.StranaA.PredujamPoreza.P2 += due
+		}
+
+		doc.StranaB.Primatelji.P = append(doc.StranaB.Primatelji.P, joppdB{
+			P1:   i + 1,
+			P2:   t.Municipality,
+			P3:   t.Municipality,
+			P4:   t.OIB,
+			P5:   t.Name,
+			P61:  in.Recipient,
+			P62:  in.Receipt,
+			P71:  "0",
+			P72:  "0",
+			P8:   "0",
+			P9:   0,
+			P100: in.From.Format("2006-01-02"),
+			P101: in.To.Format("2006-01-02"),
+			P11:  amount(in.Amount),
+			P12:  amount(0),
+			P133: amount(in.Amount),
+			P135: amount(in.Amount),
+			P141: amount(in.IncomeTax),
+			P142: amount(in.Surtax),
+			P151: "0",
+			P152: amount(0),
+			P161: "0",
+			P162: amount(0),
+			P17:  amount(0),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// amount is a monetary amount, formatted with two decimals as required in ePorezna forms
+type amount float64
+
+func (a amount) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%.2f", float64(a))), nil
+}
+
+type joppd struct {
+	XMLName    xml.Name   `xml:"ObrazacJOPPD"`
+	Xmlns      string     `xml:"xmlns,attr"`
+	Verzija    string     `xml:"verzijaSheme,attr"`
+	Metapodaci metapodaci `xml:"Metapodaci"`
+	StranaA    joppdA     `xml:"StranaA"`
+	StranaB    struct {
+		Primatelji struct {
+			P []joppdB `xml:"P"`
+		} `xml:"Primatelji"`
+	} `xml:"StranaB"`
+}
+
+type joppdA struct {
+	DatumIzvjesca       string           `xml:"DatumIzvjesca"`
+	OznakaIzvjesca      string           `xml:"OznakaIzvjesca"`
+	VrstaIzvjesca       string           `xml:"VrstaIzvjesca"`
+	PodnositeljIzvjesca joppdPodnositelj `xml:"PodnositeljIzvjesca"`
+	BrojOsoba           int              `xml:"BrojOsoba"`
+	BrojRedaka          int              `xml:"BrojRedaka"`
+	// PredujamPoreza totals the Tax due: P1 in total, P2 on capital income and P3 on capital gains
+	PredujamPoreza struct {
+		P1 amount `xml:"P1"`
+		P2 amount `xml:"P2"`
+		P3 amount `xml:"P3"`
+	} `xml:"PredujamPoreza"`
+	IzvjesceSastavio joppdSastavio `xml:"IzvjesceSastavio"`
+}
+
+type joppdPodnositelj struct {
+	Ime     string `xml:"Ime"`
+	Prezime string `xml:"Prezime"`
+	OIB     string `xml:"OIB"`
+	Oznaka  string `xml:"Oznaka"`
+}
+
+type joppdSastavio struct {
+	Ime     string `xml:"Ime"`
+	Prezime string `xml:"Prezime"`
+}
+
+// joppdB is a page B row. Field names follow the numbered JOPPD columns
+type joppdB struct {
+	P1   int    `xml:"P1"`
+	P2   string `xml:"P2"`
+	P3   string `xml:"P3"`
+	P4   string `xml:"P4"`
+	P5   string `xml:"P5"`
+	P61  string `xml:"P61"`
+	P62  string `xml:"P62"`
+	P71  string `xml:"P71"`
+	P72  string `xml:"P72"`
+	P8   string `xml:"P8"`
+	P9   int    `xml:"P9"`
+	P100 string `xml:"P100"`
+	P101 string `xml:"P101"`
+	P11  amount `xml:"P11"`
+	P12  amount `xml:"P12"`
+	P133 amount `xml:"P133"`
+	P135 amount `xml:"P135"`
+	P141 amount `xml:"P141"`
+	P142 amount `xml:"P142"`
+	P151 string `xml:"P151"`
+	P152 amount `xml:"P152"`
+	P161 string `xml:"P161"`
+	P162 amount `xml:"P162"`
+	P17  amount `xml:"P17"`
+}
+
+// metapodaci is the Dublin Core metadata header common to all ePorezna forms
+type metapodaci struct {
+	Xmlns         string   `xml:"xmlns,attr"`
+	Naslov        dcField  `xml:"Naslov"`
+	Autor         dcField  `xml:"Autor"`
+	Datum         dcField  `xml:"Datum"`
+	Format        dcField  `xml:"Format"`
+	Jezik         dcField  `xml:"Jezik"`
+	Identifikator dcField  `xml:"Identifikator"`
+	Uskladjenost  *dcField `xml:"Uskladjenost,omitempty"`
+	Tip           dcField  `xml:"Tip"`
+	Adresant      string   `xml:"Adresant"`
+}
+
+type dcField struct {
+	Dc    string `xml:"dc,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Documents not following an official schema are created without conformsTo
+func newMetapodaci(title, author string, date time.Time, conformsTo string) metapodaci {
+	const dc = "http://purl.org/dc/elements/1.1/"
+	m := metapodaci{
+		Xmlns:         "http://e-porezna.porezna-uprava.hr/sheme/Metapodaci/v2-0",
+		Naslov:        dcField{dc + "title", title},
+		Autor:         dcField{dc + "creator", author},
+		Datum:         dcField{dc + "date", date.Format("2006-01-02T15:04:05")},
+		Format:        dcField{dc + "format", "text/xml"},
+		Jezik:         dcField{dc + "language", "hr-HR"},
+		Identifikator: dcField{dc + "identifier", uuid()},
+		Tip:           dcField{dc + "type", "Elektronički obrazac"},
+		Adresant:      "Ministarstvo Financija, Porezna uprava, Zagreb",
+	}
+	if conformsTo != "" {
+		m.Uskladjenost = &dcField{"http://purl.org/dc/terms/conformsTo", conformsTo}
+	}
+	return m
+}
+
+// uuid returns a random version 4 UUID, used as a document identifier
+func uuid() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// splitName splits a full name into the first and last name
+func splitName(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	idx := strings.LastIndex(name, " ")
+	if idx == -1 {
+		return name, ""
+	}
+	return name[:idx], name[idx+1:]
+}
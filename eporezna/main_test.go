@@ -0,0 +1,84 @@
+package eporezna
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidOIB(t *testing.T) {
+	tests := []struct {
+		oib string
+		ok  bool
+	}{
+		{"69435151530", true},
+		{"69435151531", false},
+		{"6943515153", false},
+		{"6943515153a", false},
+	}
+
+	for _, tt := range tests {
+		if err := ValidOIB(tt.oib); (err == nil) != tt.ok {
+			t.Errorf("ValidOIB(%q) = %v; want valid %v", tt.oib, err, tt.ok)
+		}
+	}
+}
+
+func TestJOPPD(t *testing.T) {
+	from, to := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	err := JOPPD(&buf, Taxpayer{OIB: "69435151530", Name: "Ana Horvat", Municipality: "01333"}, time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC), []Income{
+		{Label: CapitalGains, From: from, To: to, Amount: 1000, IncomeTax: 100, Surtax: 18},
+		{Label: Dividends, From: from, To: to, Amount: 200.5, IncomeTax: 20.05, Surtax: 3.61},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc joppd
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.StranaA.OznakaIzvjesca != "24059" {
+		t.Errorf("expected report label from the submission date, got %q", doc.StranaA.OznakaIzvjesca)
+	}
+	if a := doc.StranaA; a.BrojRedaka != 2 || a.PredujamPoreza.P1 != 141.66 || a.PredujamPoreza.P2 != 23.66 || a.PredujamPoreza.P3 != 118 {
+		t.Errorf("unexpected page A totals: %+v", a)
+	}
+	if rows := doc.StranaB.Primatelji.P; len(rows) != 2 || rows[1].P61 != Dividends.Recipient || rows[1].P11 != 200.5 || rows[1].P100 != "2023-01-01" {
+		t.Errorf("unexpected page B rows: %+v", rows)
+	}
+}
+
+func TestINODOH(t *testing.T) {
+	incomes := []ForeignIncome{{
+		Country: "US", Income: 1000, TaxPaid: 300, Creditable: 118,
+		Payments: []Payment{{Instrument: "US0378331005", Currency: "USD", Amount: 1100, TaxPaid: 330, Rate: 0.909}},
+	}}
+	taxpayer := Taxpayer{OIB: "69435151530", Name: "Ana Horvat", Municipality: "01333"}
+
+	var buf bytes.Buffer
+	if err := INODOH(&buf, taxpayer, 2023, time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC), incomes); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<!--"+unofficial+"-->") || strings.Contains(buf.String(), "ObrazacINODOH/v1-0") || strings.Contains(buf.String(), "conformsTo") {
+		t.Errorf("expected INO-DOH marked unofficial, not claiming to conform to the schema:\n%s", buf.String())
+	}
+	var doc inodoh
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if prihodi := doc.Tijelo.Prihodi.Prihod; len(prihodi) != 1 || prihodi[0].Drzava != "US" || doc.Tijelo.Ukupno.PriznatiPorez != 118 || len(doc.Prilozi.Prilog) != 1 {
+		t.Errorf("unexpected INO-DOH: %+v", doc.Tijelo)
+	}
+
+	buf.Reset()
+	if err := PrintINODOH(&buf, taxpayer, 2023, "EUR", incomes); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "US0378331005") {
+		t.Errorf("expected payments listed in printable INO-DOH:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"ibkr-report/broker"
+	"ibkr-report/fx"
+	"ibkr-report/tax"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// explain prints the derivation of a year's JOPPD and INO-DOH figures, tracing every amount back to the statement rows it came from
+// If a source country is given, only income from that source is listed
+func explain(w io.Writer, r report, year int, source string) error {
+	y, ok := r[year]
+	if !ok {
+		return fmt.Errorf("no taxable income in %d", year)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	e := &explainer{w: tw, source: source}
+
+	e.printf("JOPPD %d (%s)\n", y.year, y.currency)
+	e.printf("Tečajevi HNB na dan %s\n\n", fx.RateDate(y.year).Format("2006-01-02"))
+	if len(y.details.adjustments) > 0 {
+		e.printf("Ispravci izvoda\n")
+		for _, a := range y.details.adjustments {
+			e.printf("  %s\t%s\t\t%s\t\n", a.Date, a, a.origin)
+		}
+		e.printf("\n")
+	}
+	for _, kind := range []string{capitalGain, dividend, interest} {
+		e.joppd(y, kind)
+	}
+	e.exempt(y)
+
+	if source == "" {
+		if len(y.details.fees) > 0 {
+			e.printf("Naknade\n")
+			for _, p := range y.details.fees {
+				e.event(p)
+			}
+		}
+		if y.deductible > 0 {
+			e.printf("Odbijene naknade\t\t%.2f\t\n", -y.deductible)
+		}
+		for _, kind := range []string{capitalGain, dividend, interest} {
+			if amount := y.income[kind]; amount != 0 {
+				e.printf("%s\tnakon prebijanja gubitaka i naknada\t%.2f\t\n", kindNames[kind], amount)
+			}
+		}
+		e.printf("Dobit\t\t%.2f\t\n", y.realizedPL)
+		e.printf("Porez\t%.2f × %s\t%.2f\t\n\n", y.realizedPL, rateNames(y.taxRates), y.taxDue)
+	}
+
+	for _, src := range sortedKeys(y.foreignIncome) {
+		if source != "" && src != source {
+			continue
+		}
+		e.inodoh(src, y.foreignIncome[src], y.taxRates)
+	}
+
+	return errors.Join(e.err, tw.Flush())
+}
+
+// explainer writes explanation lines, keeping the first error
+type explainer struct {
+	w      io.Writer
+	source string
+	err    error
+}
+
+func (e *explainer) printf(format string, args ...any) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
+
+// joppd lists the profits and losses of a kind of income adding up to the JOPPD figure
+func (e *explainer) joppd(y *taxYear, kind string) {
+	var pls []pl
+	for _, p := range y.joppd {
+		if p.kind == kind && (e.source == "" || p.source == e.source) {
+			pls = append(pls, p)
+		}
+	}
+	if len(pls) == 0 {
+		return
+	}
+
+	e.printf("%s\n", kindNames[kind])
+	var total float64
+	for _, p := range pls {
+		e.event(p)
+		total += p.amount
+	}
+	e.printf("Ukupno\t\t%.2f\t\n\n", total)
+}
+
+// exempt lists the sales of instruments held for more than 2 years, not included in JOPPD
+func (e *explainer) exempt(y *taxYear) {
+	var pls []pl
+	for _, p := range y.details.disposals {
+		if !p.disposal.taxable && (e.source == "" || p.source == e.source) {
+			pls = append(pls, p)
+		}
+	}
+	if len(pls) == 0 {
+		return
+	}
+
+	e.printf("Neoporezivo, drženo dulje od 2 godine\n")
+	for _, p := range pls {
+		e.event(p)
+	}
+	e.printf("\n")
+}
+
+// inodoh lists the payments and taxes from a source country and the calculation of the INO-DOH figures
+func (e *explainer) inodoh(source string, f *foreign, rates tax.Rates) {
+	e.printf("INO-DOH %s\n", source)
+	for _, p := range f.payments {
+		e.event(p)
+	}
+	e.printf("Prihod\t\t%.2f\t\n", f.gains)
+	for _, p := range f.taxes {
+		e.event(p)
+	}
+	e.printf("Plaćeni porez\t\t%.2f\t\n", f.taxPaid)
+
+	treaty := "bez ugovora, ograničeno hrvatskim porezom"
+	if rate, ok := tax.TreatyRate(source); ok {
+		treaty = "ugovorna stopa " + percent(rate)
+	}
+	e.printf("Priznati porez\t%s\t%.2f\t\n", treaty, f.creditable)
+	if f.excess > 0 {
+		e.printf("Povrat poreza\t\t%.2f\t\n", f.excess)
+	}
+	e.printf("Porez za uplatu\t%.2f × %s - %.2f\t%.2f\t\n\n", f.gains, rateNames(rates), f.creditable, f.taxDue)
+}
+
+// event prints a single amount with its origin: the conversion, the exemption and the statement rows it was derived from
+func (e *explainer) event(p pl) {
+	date := ""
+	if !p.date.IsZero() {
+		date = p.date.Format("2006-01-02")
+	}
+
+	desc := p.instrument.ID
+	if p.instrument.ID == "" {
+		// Currency gains have no instrument
+		desc = p.source
+	}
+	if d := p.disposal; d != nil {
+		desc = fmt.Sprintf("%s %s × (%.4f - %.4f), kupljeno %s (%s)", desc, strconv.FormatFloat(d.quantity, 'f', -1, 64), d.price, d.cost, d.acquired.Format("2006-01-02"), d.lot)
+	}
+	if p.rate != 0 {
+		desc = fmt.Sprintf("%s, %.2f %s × %.6f", desc, p.original, p.currency, p.rate)
+	}
+
+	e.printf("  %s\t%s\t%.2f\t%s\t\n", date, desc, p.amount, origins(p.origins))
+}
+
+func origins(list []broker.Origin) string {
+	s := make([]string, len(list))
+	for i, o := range list {
+		s[i] = o.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+func percent(rate float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", rate*100), "0"), ".") + "%"
+}
+
+// rateNames describes the Tax rates, e.g. "11.8% (10% + prirez 18%)"
+func rateNames(r tax.Rates) string {
+	if r.Surtax == 0 {
+		return percent(r.Income)
+	}
+	return fmt.Sprintf("%s (%s + prirez %s)", percent(r.Total()), percent(r.Income), percent(r.Surtax))
+}
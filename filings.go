@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"ibkr-report/broker"
+	"ibkr-report/eporezna"
+	"ibkr-report/fx"
+	"ibkr-report/tax"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// dividendPayment is a single dividend received from abroad, with the Tax withheld at the source
+type dividendPayment struct {
+	date       time.Time
+	instrument broker.Instrument
+	currency   string
+	// amount and taxPaid are in the original currency
+	amount, taxPaid float64
+	// rate is the exchange rate on the payment date
+	rate float64
+}
+
+// filing is a JOPPD filing event, covering the dividends received from abroad in a single month
+// Amounts are in the Croatian currency of the period
+type filing struct {
+	// period is the first day of the month the dividends were received in
+	period, deadline time.Time
+	payments         []dividendPayment
+	// income and Tax totals of all payments
+	income, taxPaid, creditable, taxDue float64
+	// incomeTax and surtax split the taxDue
+	incomeTax, surtax float64
+}
+
+// filedMonthly reports whether income is a dividend from abroad, filed per payment in a monthly JOPPD
+func filedMonthly(p pl) bool {
+	return p.kind == dividend && !p.date.IsZero() && p.source != "HR"
+}
+
+// written reports whether a JOPPD XML file is written for the filing. It needs the taxpayer's details and income to report
+func (f filing) written(s settings) bool {
+	return s.oib != "" && f.income > 0
+}
+
+// yearlyIncome leaves out the dividends in the filings written and the Tax withheld from them, so that they are not taxed again in
+// the yearly JOPPD and INO-DOH. Dividends in filings not written stay in the yearly figures
+func yearlyIncome(profits, withholding []pl, filings []filing, s settings) ([]pl, []pl) {
+	type key struct {
+		instrument broker.Instrument
+		date       time.Time
+	}
+	filed := make(map[key]bool)
+	for _, f := range filings {
+		if !f.written(s) {
+			continue
+		}
+		for _, p := range f.payments {
+			filed[key{p.instrument, p.date}] = true
+		}
+	}
+	if len(filed) == 0 {
+		return profits, withholding
+	}
+
+	var yearly []pl
+	for _, p := range profits {
+		if !filedMonthly(p) || !filed[key{p.instrument, p.date}] {
+			yearly = append(yearly, p)
+		}
+	}
+	var withheld []pl
+	for _, t := range withholding {
+		if !filed[key{t.instrument, t.date}] {
+			withheld = append(withheld, t)
+		}
+	}
+	return yearly, withheld
+}
+
+// overdue reports if the filing deadline has passed
+func (f filing) overdue(now time.Time) bool {
+	return now.After(f.deadline)
+}
+
+// filingDeadline returns the deadline for a JOPPD filing of income received in a period
+// It is due by the 15th of the following month, moved to Monday if on a weekend
+func filingDeadline(period time.Time) time.Time {
+	d := time.Date(period.Year(), period.Month()+1, 15, 0, 0, 0, 0, time.UTC)
+	switch d.Weekday() {
+	case time.Saturday:
+		return d.AddDate(0, 0, 2)
+	case time.Sunday:
+		return d.AddDate(0, 0, 1)
+	}
+	return d
+}
+
+// newFilings groups dividends received from abroad into monthly filing events
+// Dividends are converted with exchange rates on the payment date and foreign Tax is credited per payment
+// Dividends without a known payment date cannot be filed per payment and are only included in yearly reports
+func newFilings(dividends, withholding []pl, r fx.DailyRater, municipality string) ([]filing, error) {
+	type key struct {
+		instrument broker.Instrument
+		date       time.Time
+	}
+	taxes := make(map[key]float64)
+	for _, t := range withholding {
+		taxes[key{t.instrument, t.date}] += math.Abs(t.original)
+	}
+
+	byPeriod := make(map[time.Time]*filing)
+	var errs []error
+	failed := make(map[int]bool)
+	for _, d := range dividends {
+		if !filedMonthly(d) {
+			continue
+		}
+
+		period := time.Date(d.date.Year(), d.date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if _, ok := byPeriod[period]; !ok {
+			byPeriod[period] = &filing{period: period, deadline: filingDeadline(period)}
+		}
+		f := byPeriod[period]
+
+		rates, err := tax.For(period.Year(), municipality)
+		if err != nil && !failed[period.Year()] {
+			// The rates are the same all year, report them once
+			failed[period.Year()] = true
+			errs = append(errs, err)
+		}
+
+		p := dividendPayment{
+			date:       d.date,
+			instrument: d.instrument,
+			currency:   d.currency,
+			amount:     d.original,
+			taxPaid:    taxes[key{d.instrument, d.date}],
+			rate:       r.RateOn(d.currency, d.date),
+		}
+		f.payments = append(f.payments, p)
+
+		income, paid := p.amount*p.rate, p.taxPaid*p.rate
+		creditable, _ := tax.Credit(d.source, income, paid, rates)
+		due := rates.Due(income) - creditable
+		f.income += income
+		f.taxPaid += paid
+		f.creditable += creditable
+		f.taxDue += due
+		f.incomeTax += due / (1 + rates.Surtax)
+		f.surtax += due - due/(1+rates.Surtax)
+	}
+
+	filings := make([]filing, 0, len(byPeriod))
+	for _, f := range byPeriod {
+		filings = append(filings, *f)
+	}
+	sort.Slice(filings, func(i, j int) bool {
+		return filings[i].period.Before(filings[j].period)
+	})
+	return filings, errors.Join(errs...)
+}
+
+// writeFilings writes a summary of dividend filing events with their deadlines to JOPPD-dividende.txt
+// If the taxpayer is known, a JOPPD XML file is also written for each event
+func writeFilings(filings []filing, s settings, now time.Time) (err error) {
+	file, err := os.Create("JOPPD-dividende.txt")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if fErr := file.Close(); fErr != nil {
+			err = errors.Join(err, fErr)
+		}
+	}()
+
+	tw := tabwriter.NewWriter(file, 0, 0, 2, ' ', 0)
+	_, err = fmt.Fprintln(tw, "Razdoblje\tRok\tValuta\tIsplate\tPrihod\tPlaćeni porez\tPriznati porez\tPorez za uplatu\tStatus\t")
+	if err != nil {
+		return err
+	}
+
+	taxpayer := eporezna.Taxpayer{OIB: s.oib, Name: s.name, Municipality: s.municipality}
+	var errs []error
+	for _, f := range filings {
+		status := ""
+		if f.overdue(now) {
+			status = "Rok istekao"
+		}
+		_, err = fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%s\t\n",
+			f.period.Format("2006-01"), f.deadline.Format("2006-01-02"), fx.Base(f.period.Year()), len(f.payments), f.income, f.taxPaid, f.creditable, f.taxDue, status)
+		if err != nil {
+			return err
+		}
+
+		if !f.written(s) {
+			continue
+		}
+		errs = append(errs, writeXML(fmt.Sprintf("JOPPD-%s.xml", f.period.Format("2006-01")), func(file *os.File) error {
+			return eporezna.JOPPD(file, taxpayer, now, []eporezna.Income{{
+				Label:     eporezna.Dividends,
+				From:      f.period,
+				To:        f.period.AddDate(0, 1, -1),
+				Amount:    f.income,
+				IncomeTax: f.incomeTax,
+				Surtax:    f.surtax,
+			}})
+		}))
+	}
+
+	return errors.Join(append(errs, tw.Flush())...)
+}
+
+// overdueFilings returns the filing events past their deadline
+func overdueFilings(filings []filing, now time.Time) []filing {
+	var out []filing
+	for _, f := range filings {
+		if f.overdue(now) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"ibkr-report/broker"
+	"ibkr-report/eporezna"
+	"ibkr-report/tax"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// joppdLabels maps kinds of income to their JOPPD labels
+var joppdLabels = map[string]eporezna.Label{
+	capitalGain: eporezna.CapitalGains,
+	dividend:    eporezna.Dividends,
+	interest:    eporezna.Interest,
+}
+
+// writeForms writes JOPPD and INO-DOH files for each year with taxable income. JOPPD is to be uploaded to ePorezna
+// INO-DOH is unofficial, to be entered by hand. It is also written in a printable version, listing the underlying payments
+func writeForms(r report, s settings) error {
+	taxpayer := eporezna.Taxpayer{OIB: s.oib, Name: s.name, Municipality: s.municipality}
+	var errs []error
+	for _, year := range r {
+		incomes, err := year.joppdIncomes(s.municipality)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(incomes) > 0 {
+			errs = append(errs, writeXML(fmt.Sprintf("JOPPD-%d.xml", year.year), func(f *os.File) error {
+				return eporezna.JOPPD(f, taxpayer, time.Now(), incomes)
+			}))
+		}
+
+		foreign := year.inodohIncomes()
+		if len(foreign) == 0 {
+			continue
+		}
+		errs = append(errs, writeXML(fmt.Sprintf("INO-DOH-%d.xml", year.year), func(f *os.File) error {
+			return eporezna.INODOH(f, taxpayer, year.year, time.Now(), foreign)
+		}))
+		errs = append(errs, writeXML(fmt.Sprintf("INO-DOH-%d.txt", year.year), func(f *os.File) error {
+			return eporezna.PrintINODOH(f, taxpayer, year.year, year.currency, foreign)
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// inodohIncomes creates an INO-DOH row for each source country, with income and Tax payments grouped by instrument
+// Income from an unknown source cannot be reported and is left out (see unknownSources)
+func (y *taxYear) inodohIncomes() []eporezna.ForeignIncome {
+	incomes := make([]eporezna.ForeignIncome, 0, len(y.foreignIncome))
+	for source, f := range y.foreignIncome {
+		if f.gains <= 0 || source == "" {
+			continue
+		}
+
+		type key struct {
+			instrument, currency string
+		}
+		payments := make(map[key]*eporezna.Payment)
+		var keys []key
+		add := func(p pl) *eporezna.Payment {
+			k := key{p.instrument.ID, p.currency}
+			if _, ok := payments[k]; !ok {
+				payments[k] = &eporezna.Payment{Instrument: k.instrument, Currency: k.currency, Rate: p.rate}
+				keys = append(keys, k)
+			}
+			return payments[k]
+		}
+		for _, p := range f.payments {
+			add(p).Amount += p.original
+		}
+		for _, p := range f.taxes {
+			add(p).TaxPaid += math.Abs(p.original)
+		}
+
+		in := eporezna.ForeignIncome{Country: source, Income: f.gains, TaxPaid: f.taxPaid, Creditable: f.creditable}
+		for _, k := range keys {
+			in.Payments = append(in.Payments, *payments[k])
+		}
+		incomes = append(incomes, in)
+	}
+
+	sort.Slice(incomes, func(i, j int) bool {
+		return incomes[i].Country < incomes[j].Country
+	})
+	return incomes
+}
+
+// unknownSources diagnoses the foreign income with no source country, left out of INO-DOH
+// Instruments without an ISIN, such as crypto assets, have no source until one is set with a sourceCountry adjustment
+func (r report) unknownSources() []broker.Diagnostic {
+	var diags []broker.Diagnostic
+	for _, y := range r.sorted() {
+		f, ok := y.foreignIncome[""]
+		if !ok || f.gains <= 0 {
+			continue
+		}
+		for _, p := range f.payments {
+			var origin broker.Origin
+			if len(p.origins) > 0 {
+				origin = p.origins[0]
+			}
+			diags = append(diags, broker.Diagnostic{Origin: origin, Section: "INO-DOH", Severity: broker.Error, Message: fmt.Sprintf(
+				"Income of %.2f %s from %s has no source country and is left out of INO-DOH %d. Set it with a sourceCountry adjustment", p.amount, y.currency, p.instrument.ID, y.year)})
+		}
+	}
+	return diags
+}
+
+// joppdIncomes creates a JOPPD page B row for each kind of income in the year
+// The rows add up to realizedPL. Dividends from abroad are filed per payment (see newFilings) and are not part of it
+func (y *taxYear) joppdIncomes(municipality string) ([]eporezna.Income, error) {
+	rates, err := tax.For(y.year, municipality)
+	if err != nil {
+		return nil, err
+	}
+
+	var incomes []eporezna.Income
+	for _, kind := range []string{capitalGain, dividend, interest} {
+		amount := y.income[kind]
+		if amount <= 0 {
+			continue
+		}
+
+		incomeTax := amount * rates.Income
+		incomes = append(incomes, eporezna.Income{
+			Label:     joppdLabels[kind],
+			From:      time.Date(y.year, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:        time.Date(y.year, 12, 31, 0, 0, 0, 0, time.UTC),
+			Amount:    amount,
+			IncomeTax: incomeTax,
+			Surtax:    incomeTax * rates.Surtax,
+		})
+	}
+	return incomes, nil
+}
+
+// writeXML creates a file and writes to it using the provided function
+func writeXML(filename string, write func(f *os.File) error) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if fErr := f.Close(); fErr != nil {
+			err = errors.Join(err, fErr)
+		}
+	}()
+
+	return write(f)
+}
@@ -12,23 +12,38 @@ import (
 	"time"
 )
 
+// HRKPerEUR is the fixed conversion rate used in the 2023 switch from HRK to EUR
+const HRKPerEUR = 7.5345
+
 type Exchange struct {
 	// grabRetries is the number of times to retry fetching the rates
 	// The HNB api is not the most reliable, so it is better to retry a few times
 	grabRetries int
 	// rates map a rate toa currency-year key (e.g. "EUR2023")
 	// This is all that's needed for Croatian tax report as the tha rate used is always from Dec 31 of the requested year
+	// Daily rates are stored with a currency-date key (e.g. "USD2023-05-31")
 	rates map[string]float64
 }
 
-// Rate returns the exchange rate for a given currency and year
-func (fx *Exchange) Rate(currency string, year int) float64 {
-	// Determine base currency for the year. Currency changed in 2023
-	baseCurrency := "EUR"
+// Base returns the Croatian currency for a given year. Currency changed in 2023
+func Base(year int) string {
 	if year < 2023 {
-		baseCurrency = "HRK"
+		return "HRK"
+	}
+	return "EUR"
+}
+
+// RateDate returns the date of the exchange rates used for a year: Dec 31, or today for the current year
+func RateDate(year int) time.Time {
+	if year == time.Now().Year() {
+		return time.Now().UTC().Truncate(24 * time.Hour)
 	}
-	if currency == baseCurrency {
+	return time.Date(year, 12, 31, 0, 0, 0, 0, time.UTC)
+}
+
+// Rate returns the exchange rate for a given currency and year
+func (fx *Exchange) Rate(currency string, year int) float64 {
+	if currency == Base(year) {
 		return 1.0
 	}
 
@@ -37,7 +52,24 @@ func (fx *Exchange) Rate(currency string, year int) float64 {
 	if rate, ok := fx.rates[key]; ok {
 		return rate
 	}
-	if err := fx.grabRates(year, currency); err != nil {
+	if err := fx.grabRates(RateDate(year), currency, func(c string) string { return fmt.Sprintf("%s%d", c, year) }); err != nil {
+		log.Fatal(err)
+	}
+	return fx.rates[key]
+}
+
+// RateOn returns the exchange rate for a given currency on a specific date, in the Croatian currency of that date
+func (fx *Exchange) RateOn(currency string, date time.Time) float64 {
+	if currency == Base(date.Year()) {
+		return 1.0
+	}
+
+	day := date.Format("2006-01-02")
+	key := currency + day
+	if rate, ok := fx.rates[key]; ok {
+		return rate
+	}
+	if err := fx.grabRates(date, currency, func(c string) string { return c + day }); err != nil {
 		log.Fatal(err)
 	}
 	return fx.rates[key]
@@ -48,25 +80,27 @@ type Rater interface {
 	Rate(currency string, year int) float64
 }
 
-// url composes the fx exchange rate url for a given currency and year
+// DailyRater is a Rater also providing exchange rates on a specific date
+type DailyRater interface {
+	Rater
+	RateOn(currency string, date time.Time) float64
+}
+
+// url composes the fx exchange rate url for a given currency and date
 // It accounts for the 2024 currency change and has a default set of currencies to get rates for, to avoid multiple fetches in common currencies
-func url(currency string, year int) string {
+func url(currency string, date time.Time) string {
 	// Url base
 	url := strings.Builder{}
 	url.WriteString("https://api.hnb.hr/tecajn")
 	// Year-specific version
-	if year < 2023 {
+	if date.Year() < 2023 {
 		url.WriteString("/v2")
 	} else {
 		url.WriteString("-eur/v3")
 	}
 	// Date
 	url.WriteString("?datum-primjene=")
-	if year == time.Now().Year() {
-		url.WriteString(time.Now().UTC().Format("2006-01-02"))
-	} else {
-		url.WriteString(strconv.Itoa(year) + "-12-31")
-	}
+	url.WriteString(date.Format("2006-01-02"))
 	// Fetch for requested currencies plus a default set of common ones
 	currencyIncluded := false
 	for _, curr := range []string{"EUR", "USD", "GBP", "CHF", "CAD", "AUD", "JPY"} {
@@ -82,15 +116,16 @@ func url(currency string, year int) string {
 	return url.String()
 }
 
-func (fx *Exchange) grabRates(year int, currency string) (err error) {
-	if year <= 1900 {
+// grabRates fetches the rates for a given date and stores them under a key provided for each currency
+func (fx *Exchange) grabRates(date time.Time, currency string, key func(currency string) string) (err error) {
+	if date.Year() <= 1900 {
 		return errors.New("invalid year")
 	}
 
 	var resp hnbApiResponse
 	var response *http.Response
 	for r := 0; r < fx.grabRetries; r++ {
-		response, err = http.Get(url(currency, year))
+		response, err = http.Get(url(currency, date))
 		if err == nil {
 			break
 		}
@@ -120,12 +155,11 @@ func (fx *Exchange) grabRates(year int, currency string) (err error) {
 	}
 
 	for _, r := range resp.Rates {
-		storeKey := fmt.Sprintf("%s%d", r.Currency, year)
 		rate, err := parseFloat(r.Rate)
 		if err != nil {
 			log.Println("could not convert rate", r.Rate, "to float")
 		}
-		fx.rates[storeKey] = rate
+		fx.rates[key(r.Currency)] = rate
 	}
 	return
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"ibkr-report/broker"
+	"ibkr-report/fx"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// holding is an open purchase lot, valued at the last known price of the instrument
+type holding struct {
+	// lot is the purchase, with the quantity left after matching sales
+	lot broker.Trade
+	// exempt is the date from which a sale of the lot is exempt from Tax
+	exempt time.Time
+	// price is the last known price. It is zero if there is none
+	price broker.Price
+	// cost and value are in the Croatian currency, converted with the same rate, as for a sale in the current year
+	cost, value float64
+	rate        float64
+}
+
+// unrealized returns the profit or loss if the lot was sold at the last known price
+func (h holding) unrealized() float64 {
+	if h.price.Price == 0 {
+		return 0
+	}
+	return h.value - h.cost
+}
+
+// daysToExemption returns the number of days until a sale of the lot is exempt from Tax. Zero if already exempt
+func (h holding) daysToExemption(now time.Time) int {
+	days := math.Ceil(h.exempt.Sub(now).Hours() / 24)
+	return int(math.Max(0, days))
+}
+
+// newHoldings values open lots at the last known prices, with exchange rates of the current year
+func newHoldings(open []broker.Trade, prices map[broker.Instrument]broker.Price, r fx.Rater, now time.Time) []holding {
+	holdings := make([]holding, 0, len(open))
+	for _, lot := range open {
+		h := holding{
+			lot:    lot,
+			exempt: lot.Time.AddDate(2, 0, 0),
+			rate:   r.Rate(lot.Currency, now.Year()),
+		}
+		h.cost = lot.Quantity * lot.Price * h.rate
+		// Prices in a different currency than the purchase cannot be compared
+		if p, ok := prices[lot.Instrument]; ok && (p.Currency == "" || p.Currency == lot.Currency) {
+			h.price = p
+			h.value = lot.Quantity * p.Price * h.rate
+		}
+		holdings = append(holdings, h)
+	}
+
+	sort.Slice(holdings, func(i, j int) bool {
+		if holdings[i].lot.Instrument.ID == holdings[j].lot.Instrument.ID {
+			return holdings[i].lot.Time.Before(holdings[j].lot.Time)
+		}
+		return holdings[i].lot.Instrument.ID < holdings[j].lot.Instrument.ID
+	})
+	return holdings
+}
+
+// writeHoldings writes the open lots with their cost, value and Tax exemption dates to holdings.txt
+func writeHoldings(holdings []holding, now time.Time) (err error) {
+	file, err := os.Create("holdings.txt")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if fErr := file.Close(); fErr != nil {
+			err = errors.Join(err, fErr)
+		}
+	}()
+
+	base := fx.Base(now.Year())
+	tw := tabwriter.NewWriter(file, 0, 0, 2, ' ', 0)
+	_, err = fmt.Fprintf(tw, "Instrument\tKoličina\tDatum kupnje\tValuta\tNabavna cijena\tTrošak %s\tZadnja cijena\tDatum cijene\tVrijednost %s\tNerealizirana dobit\tNeoporezivo od\tDana do izuzeća\t\n", base, base)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range holdings {
+		price, priceDate, value, unrealized := "", "", "", ""
+		if h.price.Price != 0 {
+			price = fmt.Sprintf("%.4f", h.price.Price)
+			value = fmt.Sprintf("%.2f", h.value)
+			unrealized = fmt.Sprintf("%.2f", h.unrealized())
+			if !h.price.Date.IsZero() {
+				priceDate = h.price.Date.Format("2006-01-02")
+			}
+		}
+		_, err = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.4f\t%.2f\t%s\t%s\t%s\t%s\t%s\t%d\t\n",
+			h.lot.Instrument.ID, strconv.FormatFloat(h.lot.Quantity, 'f', -1, 64), h.lot.Time.Format("2006-01-02"), h.lot.Currency,
+			h.lot.Price, h.cost, price, priceDate, value, unrealized, h.exempt.Format("2006-01-02"), h.daysToExemption(now))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"ibkr-report/fx"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+//go:embed report.html.tmpl
+var htmlTemplate string
+
+// htmlWriter writes the report as a single static HTML page, with figures expandable to the underlying events and charts of realized profits
+type htmlWriter struct{}
+
+// htmlYear is a tax year prepared for the HTML template
+type htmlYear struct {
+	Year                         int
+	Currency                     string
+	RealizedPL, TaxDue, Deducted float64
+	Sources                      []htmlForeign
+	CurrencyGains                map[string]float64
+	Disposals                    []htmlDisposal
+	Payments, Taxes, Fees        []htmlEvent
+	Rates                        []usedRate
+	Statements                   []string
+}
+
+type htmlForeign struct {
+	Source                                     string
+	Gains, TaxPaid, Creditable, Excess, TaxDue float64
+}
+
+// htmlDisposal is a sale matched to a single purchase lot
+type htmlDisposal struct {
+	Instrument, Acquired, Sold, Currency          string
+	Quantity, Cost, Price, Rate, Original, Amount float64
+	Taxable                                       bool
+}
+
+// htmlEvent is a dividend, interest, Tax or fee payment
+type htmlEvent struct {
+	Date, Kind, Instrument, Source, Currency string
+	Original, Rate, Amount                   float64
+}
+
+// usedRate is an exchange rate used for a year's figures
+type usedRate struct {
+	Currency string
+	Rate     float64
+}
+
+// bar is a single bar in an SVG bar chart. Coordinates are in the chart's units
+type bar struct {
+	Label              string
+	Value              float64
+	X, Y, W, H, LX, LY float64
+}
+
+const chartWidth, chartHeight = 600.0, 200.0
+
+func (htmlWriter) write(w io.Writer, r report) error {
+	tmpl, err := template.New("report").Parse(htmlTemplate)
+	if err != nil {
+		return err
+	}
+
+	var years []htmlYear
+	sorted := r.sorted()
+	for _, y := range sorted {
+		years = append(years, newHTMLYear(y))
+	}
+
+	return tmpl.Execute(w, struct {
+		Years             []htmlYear
+		PerYear, BySource []bar
+		Width, Height     float64
+	}{years, layoutBars(yearBars(sorted)), layoutBars(sourceBars(sorted)), chartWidth, chartHeight})
+}
+
+// yearBars returns the realized profits of each year, in EUR, so that years reported in HRK compare with the later ones
+func yearBars(years []*taxYear) []bar {
+	var bars []bar
+	for _, y := range years {
+		bars = append(bars, bar{Label: strconv.Itoa(y.year), Value: y.realizedPL * toEUR(y)})
+	}
+	return bars
+}
+
+// sourceBars sums the taxable profits of all years by source country, in EUR, so that the currencies are not mixed
+func sourceBars(years []*taxYear) []bar {
+	perSource := make(map[string]float64)
+	for _, y := range years {
+		rate := toEUR(y)
+		for _, p := range append(append([]pl{}, y.details.disposals...), y.details.payments...) {
+			if p.disposal != nil && !p.disposal.taxable {
+				continue
+			}
+			source := p.source
+			if source == "" {
+				source = "?"
+			}
+			perSource[source] += p.amount * rate
+		}
+	}
+
+	var bars []bar
+	for _, source := range sortedKeys(perSource) {
+		bars = append(bars, bar{Label: source, Value: perSource[source]})
+	}
+	return bars
+}
+
+// toEUR returns the rate converting the amounts of a year to EUR. Years reported in HRK use the fixed conversion rate
+func toEUR(y *taxYear) float64 {
+	if y.currency == "HRK" {
+		return 1 / fx.HRKPerEUR
+	}
+	return 1
+}
+
+func newHTMLYear(y *taxYear) htmlYear {
+	hy := htmlYear{
+		Year:          y.year,
+		Currency:      y.currency,
+		RealizedPL:    y.realizedPL,
+		TaxDue:        y.taxDue,
+		Deducted:      y.deductible,
+		CurrencyGains: y.currencyGains,
+		Rates:         y.rates(),
+		Statements:    y.statements,
+	}
+	for _, source := range sortedKeys(y.foreignIncome) {
+		f := y.foreignIncome[source]
+		hy.Sources = append(hy.Sources, htmlForeign{Source: source, Gains: f.gains, TaxPaid: f.taxPaid, Creditable: f.creditable, Excess: f.excess, TaxDue: f.taxDue})
+	}
+	for _, p := range y.details.disposals {
+		hy.Disposals = append(hy.Disposals, htmlDisposal{
+			Instrument: p.instrument.ID,
+			Acquired:   p.disposal.acquired.Format("2006-01-02"),
+			Sold:       p.date.Format("2006-01-02"),
+			Currency:   p.currency,
+			Quantity:   p.disposal.quantity,
+			Cost:       p.disposal.cost,
+			Price:      p.disposal.price,
+			Rate:       p.rate,
+			Original:   p.original,
+			Amount:     p.amount,
+			Taxable:    p.disposal.taxable,
+		})
+	}
+
+	event := func(p pl) htmlEvent {
+		e := htmlEvent{Kind: kindNames[p.kind], Instrument: p.instrument.ID, Source: p.source, Currency: p.currency, Original: p.original, Rate: p.rate, Amount: p.amount}
+		if !p.date.IsZero() {
+			e.Date = p.date.Format("2006-01-02")
+		}
+		return e
+	}
+	for _, p := range y.details.payments {
+		hy.Payments = append(hy.Payments, event(p))
+	}
+	for _, p := range y.details.taxes {
+		hy.Taxes = append(hy.Taxes, event(p))
+	}
+	for _, p := range y.details.fees {
+		hy.Fees = append(hy.Fees, event(p))
+	}
+	return hy
+}
+
+// layoutBars positions bars in a chart, with a baseline at zero to show losses below it
+func layoutBars(bars []bar) []bar {
+	if len(bars) == 0 {
+		return bars
+	}
+
+	maxV, minV := 0.0, 0.0
+	for _, b := range bars {
+		maxV, minV = math.Max(maxV, b.Value), math.Min(minV, b.Value)
+	}
+	span := maxV - minV
+	if span == 0 {
+		span = 1
+	}
+
+	// Leave room for labels at the bottom
+	plot := chartHeight - 20
+	zero := plot * maxV / span
+	slot := chartWidth / float64(len(bars))
+	for i := range bars {
+		h := plot * math.Abs(bars[i].Value) / span
+		bars[i].X = float64(i)*slot + slot*0.1
+		bars[i].W = slot * 0.8
+		bars[i].H = h
+		bars[i].Y = zero - h
+		if bars[i].Value < 0 {
+			bars[i].Y = zero
+		}
+		bars[i].LX = bars[i].X + bars[i].W/2
+		bars[i].LY = chartHeight - 5
+	}
+	return bars
+}
+
+// sorted returns the report years in chronological order
+func (r report) sorted() []*taxYear {
+	years := make([]*taxYear, 0, len(r))
+	for _, y := range r {
+		years = append(years, y)
+	}
+	sort.Slice(years, func(i, j int) bool {
+		return years[i].year < years[j].year
+	})
+	return years
+}
+
+// rates lists the exchange rates used for the year's figures
+func (y *taxYear) rates() []usedRate {
+	seen := make(map[usedRate]struct{})
+	var rates []usedRate
+	for _, pls := range [][]pl{y.details.disposals, y.details.payments, y.details.taxes, y.details.fees} {
+		for _, p := range pls {
+			hr := usedRate{Currency: p.currency, Rate: p.rate}
+			if _, ok := seen[hr]; ok || p.currency == "" {
+				continue
+			}
+			seen[hr] = struct{}{}
+			rates = append(rates, hr)
+		}
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].Currency == rates[j].Currency {
+			return rates[i].Rate < rates[j].Rate
+		}
+		return rates[i].Currency < rates[j].Currency
+	})
+	return rates
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"ibkr-report/broker"
 	"io"
-	"log"
+	"math"
 	"os"
 	"regexp"
 	"slices"
@@ -17,20 +17,25 @@ import (
 )
 
 type instrument struct {
-	isin     string
+	broker.Instrument
 	category string
 }
 
 type reader struct {
-	header []string
-	rows   []map[string]string
-	isins  map[string]instrument
+	filename    string
+	header      []string
+	rows        []map[string]string
+	instruments map[string]instrument
+	diagnostics []broker.Diagnostic
 }
 
-func (r *reader) readRow(row []string) {
-	sections := []string{"Financial Instrument Information", "Trades", "Dividends", "Withholding Tax", "Fees"}
+// readRow processes a csv row read from a line of the statement file
+func (r *reader) readRow(row []string, line int) {
+	sections := []string{"Statement", "Account Information", "Financial Instrument Information", "Trades", "Dividends", "Withholding Tax", "Fees", "Interest", "Corporate Actions",
+		"Open Positions", "Mark-to-Market Performance Summary", "Transfers",
+		"Realized & Unrealized Performance Summary"}
 	// Ignore if not a section we're interested in
-	if !slices.Contains(sections, row[0]) {
+	if len(row) < 2 || !slices.Contains(sections, row[0]) {
 		return
 	}
 
@@ -40,14 +45,15 @@ func (r *reader) readRow(row []string) {
 		return
 	}
 
-	// If this is financial instrument information, add symbols to isins map, otherwise store the line for later processing
+	// If this is financial instrument information, add symbols to instruments map, otherwise store the line for later processing
 	if row[0] == "Financial Instrument Information" {
 		lm, err := mapIbkrLine(row, r.header)
 		if err != nil {
 			return
 		}
+		category := importCategory(lm["Asset Category"])
 		for _, s := range strings.Split(strings.ReplaceAll(lm["Symbol"], " ", ""), ",") {
-			r.isins[s] = instrument{isin: formatISIN(lm["Security ID"]), category: importCategory(lm["Asset Category"])}
+			r.instruments[s] = instrument{Instrument: newInstrument(lm["Security ID"], s, category), category: category}
 		}
 		return
 	}
@@ -57,6 +63,7 @@ func (r *reader) readRow(row []string) {
 		return
 	}
 
+	lm["Line"] = strconv.Itoa(line)
 	r.rows = append(r.rows, lm)
 
 }
@@ -66,7 +73,7 @@ func (r *reader) readRow(row []string) {
 func Read(filename string) (stmt *broker.Statement, err error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Printf("cannot open file %s %v", filename, err)
+		return nil, err
 	}
 	defer func() {
 		if fErr := file.Close(); fErr != nil {
@@ -81,74 +88,160 @@ func Read(filename string) (stmt *broker.Statement, err error) {
 	csvRdr.LazyQuotes = true
 
 	// Confirm this is IBKR statement. Must have "Interactive Brokers" in second row, 4th column
-	_, _ = csvRdr.Read()
+	header, _ := csvRdr.Read()
 	row, _ := csvRdr.Read()
 	if len(row) < 4 || !strings.HasPrefix(row[3], "Interactive Brokers") {
 		return nil, broker.ErrNotRecognized
 	}
 
-	rdr := reader{isins: make(map[string]instrument)}
+	// The rows read to recognize the statement are the header and first row of the Statement section
+	rdr := reader{filename: filename, instruments: make(map[string]instrument)}
+	rdr.readRow(header, 1)
+	rdr.readRow(row, 2)
 	for {
 		row, err := csvRdr.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Printf("could not read csv row: %v", err)
+			origin := broker.Origin{File: filename}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				origin.Line = parseErr.Line
+			}
+			rdr.diagnostics = append(rdr.diagnostics, broker.Diagnostic{Origin: origin, Severity: broker.Warning, Message: fmt.Sprintf("could not read csv row: %v", err)})
 			continue
 		}
 
-		rdr.readRow(row)
+		line, _ := csvRdr.FieldPos(0)
+		rdr.readRow(row, line)
 	}
 
 	return rdr.statement(filename)
 }
 
 func (r *reader) statement(filename string) (*broker.Statement, error) {
-	stmt := &broker.Statement{Filename: filename, Broker: "IBKR"}
+	r.filename = filename
+	stmt := &broker.Statement{Filename: filename, Broker: "IBKR", Account: r.field("Account Information", "Account"), Period: r.period()}
+	// The performance summary is in the base currency of the account
+	base := r.field("Account Information", "Base Currency")
 	for _, row := range r.rows {
 		currency := row["Currency"]
+		line, _ := strconv.Atoi(row["Line"])
+		origin := broker.Origin{File: filename, Line: line}
 
 		section := row["Section"]
 		if section == "Trades" {
-			if row["Date/Time"] == "" || row["Asset Category"] == "Forex" || row["Symbol"] == "" {
+			if row["Date/Time"] == "" || row["Symbol"] == "" {
 				continue
 			}
 
 			t, err := timeFromExact(row["Date/Time"])
 			if err != nil {
+				r.diagnose(row, broker.Error, "trade skipped: %v", err)
 				continue
 			}
 
+			if row["Asset Category"] == "Forex" {
+				if c, ok := r.conversion(row, *t); ok {
+					c.Origin = origin
+					stmt.Conversions = append(stmt.Conversions, c)
+				}
+				continue
+			}
+
+			ins := r.instrument(row["Symbol"])
+			price := r.amount(row, "T. Price")
+			if ins.category == broker.Bond {
+				// Bond prices are quoted as a percentage of face value
+				price /= 100
+			}
+
 			stmt.Trades = append(stmt.Trades, broker.Trade{
-				ISIN:     r.isins[row["Symbol"]].isin,
-				Category: r.isins[row["Symbol"]].category,
-				Time:     *t,
-				Currency: currency,
-				Quantity: amountFromString(row["Quantity"]),
-				Price:    amountFromString(row["T. Price"]),
+				Instrument: ins.Instrument,
+				Category:   ins.category,
+				Time:       *t,
+				Currency:   currency,
+				Quantity:   r.amount(row, "Quantity"),
+				Price:      price,
+				Origin:     origin,
 			})
 
 			stmt.Fees = append(stmt.Fees, broker.Tx{
-				Category: r.isins[row["Symbol"]].category,
+				Category: ins.category,
 				Currency: currency,
-				Amount:   amountFromString(row["Comm/Fee"]),
+				Amount:   r.amount(row, "Comm/Fee"),
 				Year:     t.Year(),
+				Origin:   origin,
 			})
 
 			continue
 		}
 
+		if section == "Open Positions" || section == "Mark-to-Market Performance Summary" {
+			if p, ok := r.price(row, stmt.Period.To); ok {
+				stmt.Prices = append(stmt.Prices, p)
+			}
+			continue
+		}
+
+		if section == "Realized & Unrealized Performance Summary" {
+			if realized, ok := r.realized(row); ok {
+				realized.Currency = base
+				realized.Origin = origin
+				stmt.Realized = append(stmt.Realized, realized)
+			}
+			continue
+		}
+
+		if section == "Corporate Actions" {
+			if trade, ok := r.redemption(row); ok {
+				trade.Origin = origin
+				stmt.Trades = append(stmt.Trades, trade)
+			}
+			continue
+		}
+
 		// All other sections only need Year as Time
 		if row["Date"] == "" {
 			continue
 		}
 
+		if section == "Transfers" {
+			if t, ok := r.transfer(row); ok {
+				t.Origin = origin
+				stmt.Transfers = append(stmt.Transfers, t)
+			}
+			continue
+		}
+
 		if section == "Fees" {
 			stmt.Fees = append(stmt.Fees, broker.Tx{
 				Currency: currency,
-				Amount:   amountFromString(row["Amount"]),
+				Amount:   r.amount(row, "Amount"),
 				Year:     yearFromDate(row["Date"]),
+				Date:     dateFromString(row["Date"]),
+				Origin:   origin,
+			})
+
+			continue
+		}
+
+		if section == "Interest" {
+			// Only bond coupons and accrued interest are of interest here
+			ins, ok := r.bondFromDescription(row["Description"])
+			if !ok {
+				continue
+			}
+
+			stmt.FixedIncome = append(stmt.FixedIncome, broker.Tx{
+				Instrument: ins.Instrument,
+				Category:   broker.Bond,
+				Currency:   currency,
+				Amount:     r.amount(row, "Amount"),
+				Year:       yearFromDate(row["Date"]),
+				Date:       dateFromString(row["Date"]),
+				Origin:     origin,
 			})
 
 			continue
@@ -160,24 +253,248 @@ func (r *reader) statement(filename string) (*broker.Statement, error) {
 			continue
 		}
 
+		ins := r.instrument(symbol)
 		tx := broker.Tx{
-			ISIN:     r.isins[symbol].isin,
-			Category: r.isins[symbol].category,
-			Currency: currency,
-			Amount:   amountFromString(row["Amount"]),
-			Year:     yearFromDate(row["Date"]),
+			Instrument: ins.Instrument,
+			Category:   ins.category,
+			Currency:   currency,
+			Amount:     r.amount(row, "Amount"),
+			Year:       yearFromDate(row["Date"]),
+			Date:       dateFromString(row["Date"]),
+			Origin:     origin,
 		}
 
-		if section == "Dividends" {
+		if section == "Dividends" && isReturnOfCapital(row["Description"]) {
+			stmt.ReturnOfCapital = append(stmt.ReturnOfCapital, tx)
+		} else if section == "Dividends" {
 			stmt.FixedIncome = append(stmt.FixedIncome, tx)
 		} else {
 			stmt.Tax = append(stmt.Tax, tx)
 		}
 	}
 
+	stmt.Diagnostics = r.diagnostics
 	return stmt, nil
 }
 
+// amount reads a number from a column of a row. Numbers that cannot be read are diagnosed as errors and read as zero
+func (r *reader) amount(row map[string]string, column string) float64 {
+	f, err := amountFromString(row[column])
+	if err != nil {
+		r.diagnose(row, broker.Error, "invalid %s %q, read as 0", column, row[column])
+	}
+	return f
+}
+
+// diagnose records a problem with a row of the statement
+func (r *reader) diagnose(row map[string]string, severity broker.Severity, format string, args ...any) {
+	line, _ := strconv.Atoi(row["Line"])
+	r.diagnostics = append(r.diagnostics, broker.Diagnostic{
+		Origin:   broker.Origin{File: r.filename, Line: line},
+		Section:  row["Section"],
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// field returns the value of a field in a section listing field names and values, such as the statement header
+func (r *reader) field(section, name string) string {
+	for _, row := range r.rows {
+		if row["Section"] == section && row["Field Name"] == name {
+			return row["Field Value"]
+		}
+	}
+	return ""
+}
+
+// period returns the dates the statement covers, e.g. from "January 1, 2023 - December 31, 2023"
+// Statements for a single day list only one date
+func (r *reader) period() broker.Period {
+	dates := strings.Split(r.field("Statement", "Period"), " - ")
+	from, err := time.Parse("January 2, 2006", strings.TrimSpace(dates[0]))
+	if err != nil {
+		return broker.Period{}
+	}
+	to, err := time.Parse("January 2, 2006", strings.TrimSpace(dates[len(dates)-1]))
+	if err != nil {
+		return broker.Period{}
+	}
+	return broker.Period{From: from, To: to}
+}
+
+// price reads the closing price of an open position, or the current price from the mark-to-market summary
+// Open positions are summarized per instrument, with a row for each lot. Only the summary is needed for the price
+func (r *reader) price(row map[string]string, date time.Time) (broker.Price, bool) {
+	column := "Close Price"
+	if row["Section"] == "Mark-to-Market Performance Summary" {
+		column = "Current Price"
+	}
+	if row[column] == "" || row["Symbol"] == "" || (row["DataDiscriminator"] != "" && row["DataDiscriminator"] != "Summary") {
+		return broker.Price{}, false
+	}
+
+	// Cash balances are listed with securities in the mark-to-market summary
+	category := importCategory(row["Asset Category"])
+	if category != broker.Equity && category != broker.Bond && category != broker.Crypto {
+		return broker.Price{}, false
+	}
+
+	price := r.amount(row, column)
+	if category == broker.Bond {
+		// Bond prices are quoted as a percentage of face value
+		price /= 100
+	}
+	return broker.Price{Instrument: r.instrument(row["Symbol"]).Instrument, Currency: row["Currency"], Price: price, Date: date}, true
+}
+
+// instrument looks up a traded instrument by its symbol. Symbols are stored without spaces, as bond symbols contain them
+// Instruments missing from the statement are identified by the symbol alone
+func (r *reader) instrument(symbol string) instrument {
+	symbol = strings.ReplaceAll(symbol, " ", "")
+	if ins, ok := r.instruments[symbol]; ok {
+		return ins
+	}
+	return instrument{Instrument: broker.Instrument{ID: symbol, Scheme: broker.SchemeSymbol}}
+}
+
+// newInstrument creates an instrument identifier from IBKR financial instrument information
+// Crypto assets are identified by their symbol, securities by ISIN where possible
+func newInstrument(securityID, symbol, category string) broker.Instrument {
+	if category == broker.Crypto {
+		return broker.CryptoAsset(symbol)
+	}
+
+	if id := formatISIN(securityID); len(id) == 11 {
+		return broker.ISIN(id)
+	}
+
+	return broker.Instrument{ID: symbol, Scheme: broker.SchemeSymbol}
+}
+
+// bondFromDescription finds the bond referenced in an IBKR csv interest line
+// e.g. "Bond Coupon Payment (T 2 1/2 05/31/24)" or "Purchase Accrued Interest T 2 1/2 05/31/24"
+func (r *reader) bondFromDescription(d string) (instrument, bool) {
+	lower := strings.ToLower(d)
+	if !strings.Contains(lower, "coupon") && !strings.Contains(lower, "accrued interest") {
+		return instrument{}, false
+	}
+
+	if isin := isinPattern.FindString(d); isin != "" {
+		return instrument{Instrument: broker.ISIN(formatISIN(isin)), category: broker.Bond}, true
+	}
+
+	symbol := d
+	if open, end := strings.Index(d, "("), strings.LastIndex(d, ")"); open != -1 && end > open {
+		symbol = d[open+1 : end]
+	} else if idx := strings.Index(lower, "accrued interest"); idx != -1 {
+		symbol = d[idx+len("accrued interest"):]
+	}
+
+	ins, ok := r.instruments[strings.ReplaceAll(symbol, " ", "")]
+	if !ok || ins.category != broker.Bond {
+		return instrument{}, false
+	}
+	return ins, true
+}
+
+var isinPattern = regexp.MustCompile(`\b[A-Z]{2}[A-Z0-9]{9}[0-9]\b`)
+
+// redemption converts a bond maturity or call from IBKR corporate actions into a sale at the redemption price
+func (r *reader) redemption(row map[string]string) (broker.Trade, bool) {
+	if importCategory(row["Asset Category"]) != broker.Bond {
+		return broker.Trade{}, false
+	}
+
+	lower := strings.ToLower(row["Description"])
+	if !strings.Contains(lower, "maturity") && !strings.Contains(lower, "redemption") && !strings.Contains(lower, "call") {
+		return broker.Trade{}, false
+	}
+
+	isin := isinPattern.FindString(row["Description"])
+	qty := r.amount(row, "Quantity")
+	if isin == "" || qty >= 0 {
+		return broker.Trade{}, false
+	}
+
+	t, err := timeFromExact(row["Date/Time"])
+	if err != nil {
+		// Some corporate actions only carry a report date
+		rd, err := time.Parse("2006-01-02", row["Report Date"])
+		if err != nil {
+			return broker.Trade{}, false
+		}
+		t = &rd
+	}
+
+	return broker.Trade{
+		Instrument: broker.ISIN(formatISIN(isin)),
+		Category:   broker.Bond,
+		Time:       *t,
+		Currency:   row["Currency"],
+		Quantity:   qty,
+		Price:      r.amount(row, "Proceeds") / -qty,
+	}, true
+}
+
+// transfer reads a position transferred in or out of the account. Cash transfers are ignored
+func (r *reader) transfer(row map[string]string) (broker.Transfer, bool) {
+	category := importCategory(row["Asset Category"])
+	if category != broker.Equity && category != broker.Bond && category != broker.Crypto {
+		return broker.Transfer{}, false
+	}
+
+	date := dateFromString(row["Date"])
+	qty := math.Abs(r.amount(row, "Qty"))
+	if date.IsZero() || qty == 0 || row["Symbol"] == "" {
+		return broker.Transfer{}, false
+	}
+	if strings.EqualFold(row["Direction"], "Out") {
+		qty = -qty
+	}
+
+	return broker.Transfer{Instrument: r.instrument(row["Symbol"]).Instrument, Time: date, Quantity: qty}, true
+}
+
+// realized reads the profit or loss realized on an instrument from the performance summary
+// Forex and total rows are skipped
+func (r *reader) realized(row map[string]string) (broker.Realized, bool) {
+	category := row["Asset Category"]
+	if row["Symbol"] == "" || category == "Forex" || strings.HasPrefix(category, "Total") || row["Realized Total"] == "" {
+		return broker.Realized{}, false
+	}
+
+	return broker.Realized{
+		Instrument: r.instrument(row["Symbol"]).Instrument,
+		Symbol:     row["Symbol"],
+		Amount:     r.amount(row, "Realized Total"),
+	}, true
+}
+
+// isReturnOfCapital recognizes distributions paying back capital from the dividend description
+// e.g. "VNQ(US9229085538) Cash Dividend USD 0.2353 per Share (Return of Capital)"
+func isReturnOfCapital(description string) bool {
+	return strings.Contains(strings.ToLower(description), "return of capital")
+}
+
+// conversion creates a currency conversion from an IBKR Forex trade
+// Forex symbols are currency pairs (e.g. EUR.USD), with Quantity in the base currency and Proceeds in the quote currency
+func (r *reader) conversion(row map[string]string, t time.Time) (broker.Conversion, bool) {
+	pair := strings.Split(row["Symbol"], ".")
+	if len(pair) != 2 {
+		return broker.Conversion{}, false
+	}
+
+	qty, proceeds := r.amount(row, "Quantity"), r.amount(row, "Proceeds")
+	if qty == 0 || proceeds == 0 {
+		return broker.Conversion{}, false
+	}
+
+	if qty > 0 {
+		return broker.Conversion{Time: t, From: pair[1], To: pair[0], Sold: -proceeds, Bought: qty}, true
+	}
+	return broker.Conversion{Time: t, From: pair[0], To: pair[1], Sold: -qty, Bought: proceeds}, true
+}
+
 // symbolFromDescription extracts a symbol from IBKR csv dividend lines
 func symbolFromDescription(d string) (string, error) {
 	if d == "" {
@@ -209,6 +526,18 @@ func yearFromDate(s string) int {
 	return y
 }
 
+// dateFromString extracts a date from IBKR csv date field. Returns zero time if not a valid date
+func dateFromString(s string) time.Time {
+	if len(s) < 10 {
+		return time.Time{}
+	}
+	d, err := time.Parse("2006-01-02", s[:10])
+	if err != nil {
+		return time.Time{}
+	}
+	return d
+}
+
 // timeFromExact extracts time.Time from IBKR csv Time field
 func timeFromExact(t string) (*time.Time, error) {
 	timeStr := strings.Join(strings.Split(t, ","), "")
@@ -268,55 +597,21 @@ func importCategory(c string) string {
 
 	lc := strings.ToLower(c)
 	if strings.HasPrefix(lc, "stock") || strings.HasPrefix(lc, "equit") {
-		return "Equity"
+		return broker.Equity
 	}
-
-	return c
-}
-
-func amountFromStringOld(s string) float64 {
-	if s == "" {
-		return 0
-
+	if strings.HasPrefix(lc, "bond") {
+		return broker.Bond
 	}
-	// Remove all but numbers, commas and points
-	re := regexp.MustCompile(`[0-9.,-]`)
-	ss := strings.Join(re.FindAllString(s, -1), "")
-	isNeg := ss[0] == '-'
-	// Find all commas and points
-	// If none found, return 0, print error
-	signs := regexp.MustCompile(`[.,]`).FindAllString(ss, -1)
-	if len(signs) == 0 {
-		f, err := strconv.ParseFloat(ss, 64)
-		if err != nil {
-			fmt.Printf("could not convert %s to number", s)
-			return 0
-		}
-
-		return f
+	if strings.HasPrefix(lc, "crypto") {
+		return broker.Crypto
 	}
 
-	// Use last sign as decimal separator and ignore others
-	// Find idx and replace whatever sign was to a decimal point
-	sign := signs[len(signs)-1]
-	signIdx := strings.LastIndex(ss, sign)
-	sign = "."
-	left := regexp.MustCompile(`[0-9]`).FindAllString(ss[:signIdx], -1)
-	right := ss[signIdx+1:]
-	n, err := strconv.ParseFloat(strings.Join(append(left, []string{sign, right}...), ""), 64)
-	if err != nil {
-		fmt.Printf("could not convert %s to number", s)
-		return 0
-	}
-	if isNeg {
-		n = n * -1
-	}
-	return n
+	return c
 }
 
-func amountFromString(s string) float64 {
+func amountFromString(s string) (float64, error) {
 	if s == "" {
-		return 0
+		return 0, nil
 	}
 
 	// Remove commas, spaces and all but the last decimal point
@@ -329,8 +624,8 @@ func amountFromString(s string) float64 {
 	// Convert to float
 	f, err := strconv.ParseFloat(s, 64)
 	if err != nil {
-		log.Fatal(err)
+		return 0, fmt.Errorf("could not convert %s to number", s)
 	}
 
-	return f
+	return f, nil
 }
@@ -1,34 +1,194 @@
 package ibkr
 
 import (
+	"ibkr-report/broker"
 	"testing"
+	"time"
 )
 
 func Test_amountFromString(t *testing.T) {
 	tests := []struct {
 		in  string
 		out float64
+		ok  bool
 	}{
-		{"1.23", 1.23},
-		{"-79,9 78.978 67", -79978.97867},
-		{"-79....97,,,,8.97,8 67", -79978.97867},
+		{"1.23", 1.23, true},
+		{"-79,9 78.978 67", -79978.97867, true},
+		{"-79....97,,,,8.97,8 67", -79978.97867, true},
+		{"", 0, true},
+		{"--", 0, false},
 	}
 
 	for _, tt := range tests {
-		if got := amountFromString(tt.in); got != tt.out {
-			t.Errorf("amountFromString(%q) = %v; want %v", tt.in, got, tt.out)
+		if got, err := amountFromString(tt.in); got != tt.out || (err == nil) != tt.ok {
+			t.Errorf("amountFromString(%q) = %v, %v; want %v", tt.in, got, err, tt.out)
 		}
 	}
 }
 
+func Test_amount(t *testing.T) {
+	r := reader{filename: "2023.csv"}
+	if got := r.amount(map[string]string{"Section": "Trades", "Quantity": "1O", "Line": "12"}, "Quantity"); got != 0 {
+		t.Errorf("amount = %v; want 0", got)
+	}
+	want := broker.Diagnostic{Origin: broker.Origin{File: "2023.csv", Line: 12}, Section: "Trades", Severity: broker.Error, Message: `invalid Quantity "1O", read as 0`}
+	if len(r.diagnostics) != 1 || r.diagnostics[0] != want {
+		t.Errorf("diagnostics = %+v; want %+v", r.diagnostics, want)
+	}
+}
+
 func Benchmark_amountFromString(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		amountFromString("-79....97,,,,8.97,8 67")
+		_, _ = amountFromString("-79....97,,,,8.97,8 67")
 	}
 }
 
-func Benchmark_amountFromStringOld(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		amountFromStringOld("-79....97,,,,8.97,8 67")
+func Test_bondFromDescription(t *testing.T) {
+	r := reader{instruments: map[string]instrument{
+		"T21/205/31/24": {Instrument: broker.ISIN("US91282CEQ0"), category: broker.Bond},
+		"AAPL":          {Instrument: broker.ISIN("US0378331005"), category: broker.Equity},
+	}}
+	tests := []struct {
+		in   string
+		isin string
+		ok   bool
+	}{
+		{"Bond Coupon Payment (T 2 1/2 05/31/24)", "US91282CEQ0", true},
+		{"Purchase Accrued Interest T 2 1/2 05/31/24", "US91282CEQ0", true},
+		{"Sold Accrued Interest US91282CEQ04", "US91282CEQ0", true},
+		{"USD Credit Interest for Jan-2023", "", false},
+		{"Bond Coupon Payment (AAPL)", "", false},
+	}
+
+	for _, tt := range tests {
+		ins, ok := r.bondFromDescription(tt.in)
+		if ok != tt.ok || ins.ID != tt.isin {
+			t.Errorf("bondFromDescription(%q) = %q, %v; want %q, %v", tt.in, ins.ID, ok, tt.isin, tt.ok)
+		}
+	}
+}
+
+func Test_redemption(t *testing.T) {
+	trade, ok := (&reader{}).redemption(map[string]string{
+		"Asset Category": "Bonds",
+		"Currency":       "USD",
+		"Date/Time":      "2024-05-31, 20:25:00",
+		"Description":    "(US91282CEQ04) Bond Maturity for USD 1.00 per Bond (T 2 1/2 05/31/24, US91282CEQ04)",
+		"Quantity":       "-10,000",
+		"Proceeds":       "10000",
+	})
+	if !ok {
+		t.Fatal("redemption not recognized")
+	}
+	if trade.Instrument != broker.ISIN("US91282CEQ0") || trade.Quantity != -10000 || trade.Price != 1 || trade.Category != broker.Bond {
+		t.Errorf("unexpected redemption trade: %+v", trade)
+	}
+}
+
+func Test_newInstrument(t *testing.T) {
+	tests := []struct {
+		securityID, symbol, category string
+		out                          broker.Instrument
+	}{
+		{"037833100", "AAPL", broker.Equity, broker.Instrument{ID: "US037833100", Scheme: broker.SchemeISIN, Country: "US"}},
+		{"IE00B4L5Y983", "IWDA", broker.Equity, broker.Instrument{ID: "IE00B4L5Y98", Scheme: broker.SchemeISIN, Country: "IE"}},
+		{"", "BTC", broker.Crypto, broker.Instrument{ID: "BTC", Scheme: broker.SchemeCrypto}},
+		{"", "XYZ", broker.Equity, broker.Instrument{ID: "XYZ", Scheme: broker.SchemeSymbol}},
+	}
+
+	for _, tt := range tests {
+		if got := newInstrument(tt.securityID, tt.symbol, tt.category); got != tt.out {
+			t.Errorf("newInstrument(%q, %q, %q) = %+v; want %+v", tt.securityID, tt.symbol, tt.category, got, tt.out)
+		}
+	}
+}
+
+func Test_price(t *testing.T) {
+	r := reader{instruments: map[string]instrument{
+		"AAPL": {Instrument: broker.ISIN("US0378331005"), category: broker.Equity},
+	}}
+	r.rows = []map[string]string{
+		{"Section": "Statement", "Field Name": "Period", "Field Value": "January 1, 2023 - December 31, 2023"},
+		{"Section": "Account Information", "Field Name": "Account", "Field Value": "U1234567"},
+	}
+	period := r.period()
+	if period.From != time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC) || period.To != time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC) {
+		t.Fatalf("period() = %v; want 2023-01-01 - 2023-12-31", period)
+	}
+	if account := r.field("Account Information", "Account"); account != "U1234567" {
+		t.Errorf("account = %q; want U1234567", account)
+	}
+	end := period.To
+
+	tests := []struct {
+		row   map[string]string
+		price float64
+		ok    bool
+	}{
+		{map[string]string{"Section": "Open Positions", "DataDiscriminator": "Summary", "Asset Category": "Stocks", "Currency": "USD", "Symbol": "AAPL", "Close Price": "192.53"}, 192.53, true},
+		{map[string]string{"Section": "Open Positions", "DataDiscriminator": "Lot", "Asset Category": "Stocks", "Currency": "USD", "Symbol": "AAPL", "Close Price": "192.53"}, 0, false},
+		{map[string]string{"Section": "Mark-to-Market Performance Summary", "Asset Category": "Stocks", "Symbol": "AAPL", "Current Price": "192.53"}, 192.53, true},
+		{map[string]string{"Section": "Mark-to-Market Performance Summary", "Asset Category": "Forex", "Symbol": "USD", "Current Price": "0.9"}, 0, false},
+	}
+
+	for _, tt := range tests {
+		p, ok := r.price(tt.row, end)
+		if ok != tt.ok || p.Price != tt.price || (ok && (p.Instrument.ID != "US0378331005" || p.Date != end)) {
+			t.Errorf("price(%v) = %+v, %v; want %v, %v", tt.row, p, ok, tt.price, tt.ok)
+		}
+	}
+}
+
+func Test_realized(t *testing.T) {
+	r := reader{instruments: map[string]instrument{
+		"AAPL": {Instrument: broker.ISIN("US0378331005"), category: broker.Equity},
+	}}
+	r.rows = []map[string]string{
+		{"Section": "Account Information", "Field Name": "Base Currency", "Field Value": "EUR"},
+		{"Section": "Realized & Unrealized Performance Summary", "Asset Category": "Stocks", "Symbol": "AAPL", "Realized Total": "-120.5", "Line": "42"},
+		{"Section": "Realized & Unrealized Performance Summary", "Asset Category": "Forex", "Symbol": "USD", "Realized Total": "3.1"},
+		{"Section": "Realized & Unrealized Performance Summary", "Asset Category": "Total (All Assets)", "Realized Total": "-117.4"},
+	}
+
+	stmt, err := r.statement("2023.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := broker.Realized{Instrument: broker.ISIN("US0378331005"), Symbol: "AAPL", Currency: "EUR", Amount: -120.5, Origin: broker.Origin{File: "2023.csv", Line: 42}}
+	if len(stmt.Realized) != 1 || stmt.Realized[0] != want {
+		t.Errorf("Realized = %+v; want %+v", stmt.Realized, want)
+	}
+}
+
+func Test_isReturnOfCapital(t *testing.T) {
+	if !isReturnOfCapital("VNQ(US9229085538) Cash Dividend USD 0.2353 per Share (Return of Capital)") {
+		t.Error("return of capital not recognized")
+	}
+	if isReturnOfCapital("VNQ(US9229085538) Cash Dividend USD 0.8 per Share (Ordinary Dividend)") {
+		t.Error("ordinary dividend recognized as return of capital")
+	}
+}
+
+func TestRead(t *testing.T) {
+	stmt, err := Read("testdata/activity-2023.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := broker.Period{From: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)}
+	if stmt.Period != want || stmt.Account != "U1234567" {
+		t.Errorf("Period = %v, Account = %q; want %v, U1234567", stmt.Period, stmt.Account, want)
+	}
+	if len(stmt.Trades) != 1 || stmt.Trades[0].Instrument.ID != "US037833100" || stmt.Trades[0].Origin.Line != 16 {
+		t.Errorf("unexpected trades: %+v", stmt.Trades)
+	}
+	if len(stmt.Prices) != 1 || stmt.Prices[0].Price != 192.53 || stmt.Prices[0].Date != want.To {
+		t.Errorf("expected the closing price dated at the end of the period, got %+v", stmt.Prices)
+	}
+	if len(stmt.FixedIncome) != 1 || stmt.FixedIncome[0].Amount != 2.4 {
+		t.Errorf("unexpected dividends: %+v", stmt.FixedIncome)
+	}
+	if len(stmt.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %v", stmt.Diagnostics)
 	}
 }
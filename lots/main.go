@@ -0,0 +1,118 @@
+package lots
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"ibkr-report/broker"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Broker names the statements of opening lots
+const Broker = "Opening lots"
+
+// header is the first row of an opening lots file. Columns are matched regardless of case
+var header = []string{"isin", "date", "quantity", "cost", "currency"}
+
+// Read reads opening lots from a csv file: positions bought before the first statement, or at another broker and transferred in
+// Each row is a lot with its ISIN, acquisition date (YYYY-MM-DD), quantity, total cost and the currency of the cost
+// The lots are returned as purchases, matched to later sales like any other. Invalid rows are skipped and diagnosed
+func Read(filename string) (*broker.Statement, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rdr := csv.NewReader(file)
+	rdr.FieldsPerRecord = -1
+	first, err := rdr.Read()
+	if err != nil || !isHeader(first) {
+		return nil, broker.ErrNotRecognized
+	}
+
+	stmt := &broker.Statement{Broker: Broker, Filename: filename}
+	for {
+		row, err := rdr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// The reader resumes on the next row
+			origin := broker.Origin{File: filename, Line: parseErr.StartLine}
+			stmt.Diagnostics = append(stmt.Diagnostics, broker.Diagnostic{Origin: origin, Severity: broker.Error, Message: "lot skipped: " + parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := rdr.FieldPos(0)
+		origin := broker.Origin{File: filename, Line: line}
+		trade, err := lot(row)
+		if err != nil {
+			stmt.Diagnostics = append(stmt.Diagnostics, broker.Diagnostic{Origin: origin, Severity: broker.Error, Message: "lot skipped: " + err.Error()})
+			continue
+		}
+		trade.Origin = origin
+		stmt.Trades = append(stmt.Trades, trade)
+	}
+
+	return stmt, nil
+}
+
+func isHeader(row []string) bool {
+	if len(row) != len(header) {
+		return false
+	}
+	for i, field := range row {
+		row[i] = strings.ToLower(strings.TrimSpace(field))
+	}
+	return slices.Equal(row, header)
+}
+
+func lot(row []string) (broker.Trade, error) {
+	if len(row) != len(header) {
+		return broker.Trade{}, fmt.Errorf("expected %d columns, got %d", len(header), len(row))
+	}
+	for i := range row {
+		row[i] = strings.TrimSpace(row[i])
+	}
+
+	isin := strings.ToUpper(row[0])
+	if len(isin) != 11 && len(isin) != 12 {
+		return broker.Trade{}, fmt.Errorf("invalid ISIN %q", row[0])
+	}
+	// Instruments are identified by ISIN without the check digit
+	isin = isin[:11]
+
+	date, err := time.Parse("2006-01-02", row[1])
+	if err != nil {
+		return broker.Trade{}, fmt.Errorf("invalid date %q", row[1])
+	}
+	qty, err := strconv.ParseFloat(row[2], 64)
+	if err != nil || qty <= 0 {
+		return broker.Trade{}, fmt.Errorf("invalid quantity %q", row[2])
+	}
+	cost, err := strconv.ParseFloat(row[3], 64)
+	if err != nil || cost < 0 {
+		return broker.Trade{}, fmt.Errorf("invalid cost %q", row[3])
+	}
+	if len(row[4]) != 3 {
+		return broker.Trade{}, fmt.Errorf("invalid currency %q", row[4])
+	}
+
+	return broker.Trade{
+		Instrument: broker.ISIN(isin),
+		Category:   broker.Equity,
+		Time:       date,
+		Currency:   strings.ToUpper(row[4]),
+		Quantity:   qty,
+		Price:      cost / qty,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package lots
+
+import (
+	"errors"
+	"ibkr-report/broker"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	if _, err := Read(write("other.csv", "Statement,Header,Field Name,Field Value\n")); !errors.Is(err, broker.ErrNotRecognized) {
+		t.Errorf("expected other files not to be recognized, got %v", err)
+	}
+
+	filename := write("lots.csv", "ISIN,Date,Quantity,Cost,Currency\n"+
+		"us0378331005,2020-05-04,4,300,usd\n"+
+		"US0378331005,2020-13-01,4,300,USD\n"+
+		"US0378331005,2020-05-04,\"4\"x,300,USD\n"+
+		"US0378331005,2021-05-04,2,200,USD\n")
+	stmt, err := Read(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmt.Diagnostics) != 2 || stmt.Diagnostics[0].Origin.Line != 3 || !strings.Contains(stmt.Diagnostics[0].Message, "invalid date") {
+		t.Errorf("expected an invalid date on line 3, got %+v", stmt.Diagnostics)
+	}
+	if len(stmt.Diagnostics) == 2 && (stmt.Diagnostics[1].Origin.Line != 4 || stmt.Diagnostics[1].Severity != broker.Error) {
+		t.Errorf("expected a malformed row on line 4, got %+v", stmt.Diagnostics[1])
+	}
+	if len(stmt.Trades) != 2 {
+		t.Fatalf("expected the lots around the malformed row, got %+v", stmt.Trades)
+	}
+
+	want := broker.Trade{
+		Instrument: broker.ISIN("US037833100"),
+		Category:   broker.Equity,
+		Time:       time.Date(2020, 5, 4, 0, 0, 0, 0, time.UTC),
+		Currency:   "USD",
+		Quantity:   4,
+		Price:      75,
+		Origin:     broker.Origin{File: filename, Line: 2},
+	}
+	if got := stmt.Trades[0]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
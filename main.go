@@ -1,18 +1,21 @@
 package main
 
 import (
-	"bufio"
 	"errors"
+	"flag"
 	"fmt"
 	"ibkr-report/broker"
 	"ibkr-report/fx"
 	"ibkr-report/ibkr"
+	"ibkr-report/lots"
 	"ibkr-report/revolut"
+	"ibkr-report/tax"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -26,23 +29,242 @@ func main() {
 		fmt.Println("Finished in", time.Since(t))
 	}()
 
+	// The command is the first argument, if not a flag. Reports are written by default
+	cmd, args := "report", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	var s settings
+	fs.BoolVar(&s.fxTaxable, "fx-taxable", false, "Report currency gains on foreign cash conversions as taxable income")
+	fs.StringVar(&s.municipality, "municipality", "", "Tax administration code of the municipality of residence, used for surtax rates (e.g. 01333 for Zagreb)")
+	fs.StringVar(&s.formats, "format", "txt", "Comma-separated report formats: txt, json, csv, xlsx, html, pdf")
+	fs.StringVar(&s.oib, "oib", "", "Taxpayer OIB. If set, ePorezna forms are generated")
+	fs.StringVar(&s.name, "name", "", "Taxpayer full name, used in ePorezna forms")
+	fs.BoolVar(&s.strict, "strict", false, "Stop without writing reports if reading the statements produced warnings or errors")
+	fs.StringVar(&s.adjustments, "adjustments", "", "JSON file of manual corrections to the statements")
+	configFile := fs.String("config", defaultConfig, "JSON configuration file. Flags take precedence over its settings")
+	var year int
+	var source string
+	var sales []hypotheticalSale
+	var salesFile string
+	switch cmd {
+	case "report":
+	case "explain":
+		fs.IntVar(&year, "year", time.Now().Year()-1, "Tax year to explain")
+		fs.StringVar(&source, "source", "", "Only explain income from a source country (e.g. US)")
+	case "plan", "reconcile":
+	case "whatif":
+		fs.Func("sell", "Hypothetical sale as ISIN,quantity,date,price (e.g. US0378331005,10,2024-12-20,190.5). May be repeated", func(v string) error {
+			sale, err := parseSale(strings.Split(v, ","))
+			sales = append(sales, sale)
+			return err
+		})
+		fs.StringVar(&salesFile, "sales", "", "CSV file of hypothetical sales, with ISIN, quantity, date and price on each row")
+	default:
+		log.Fatalf("Unknown command: %q\n", cmd)
+	}
+	_ = fs.Parse(args)
+
+	c, err := readConfig(*configFile, *configFile != defaultConfig)
+	if err != nil {
+		log.Fatalf("Error reading configuration: %v\n", err)
+	}
+	if err := errors.Join(s.withConfig(c, fs), s.validate()); err != nil {
+		log.Fatalf("Invalid settings:\n%v\n", err)
+	}
+
 	rdr := broker.NewReader()
-	if err := rdr.Register(".csv", ibkr.Read, revolut.Read); err != nil {
-		log.Fatalf("Error registering IBKR reader: %v\n", err)
+	if err := rdr.Register(".csv", s.readers()...); err != nil {
+		log.Fatalf("Error registering statement readers: %v\n", err)
+	}
+
+	diags := &broker.Diagnostics{}
+	stmts, dropped, err := deduplicate(collect(readFiles(rdr, findFiles(s.inputs, diags), diags)))
+	for file, by := range dropped {
+		diags.Add(broker.Diagnostic{Origin: broker.Origin{File: file}, Severity: broker.Info, Message: "Skipped, covered by " + by})
+	}
+	if err != nil {
+		// The diagnostics collected so far show the files read and skipped before the conflict
+		_ = printDiagnostics(os.Stdout, diags)
+		log.Fatalf("Error reading statements: %v\n", err)
 	}
 
-	r := newReport(newLedger(readFiles(rdr, findFiles())))
-	if err := writeFile(r.toRows()); err != nil {
+	var adjs []adjustment
+	if s.adjustments != "" {
+		var sum string
+		if adjs, sum, err = readAdjustments(s.adjustments); err != nil {
+			log.Fatalf("Error reading adjustments: %v\n", err)
+		}
+		var d []broker.Diagnostic
+		stmts, d = adjust(stmts, adjs, s.adjustments, sum)
+		diags.Add(d...)
+	}
+
+	l := newLedger(stmts)
+	l.adjustments = adjs
+	l.withResidence(s)
+	diags.Add(transferWarnings(stmts)...)
+	diags.Add(coverageWarnings(stmts, l.unmatched)...)
+	for _, roc := range l.unreduced {
+		diags.Add(broker.Diagnostic{Origin: roc.Origin, Section: "Return of capital", Severity: broker.Warning, Message: fmt.Sprintf(
+			"Return of capital of %.2f %s on %s paid with no lots of %s held. The cost of no lot was lowered", roc.Amount, roc.Currency, roc.Date.Format("2006-01-02"), roc.Instrument.ID)})
+	}
+	if n := mismatches(reconcile(stmts, l)); n > 0 {
+		diags.Add(broker.Diagnostic{Severity: broker.Warning, Message: fmt.Sprintf("Realized profit of %d instruments differs from the broker's. Run ibkr-report reconcile to see them", n)})
+	}
+	r, err := newReport(l, s)
+	if err != nil {
+		diags.Add(broker.Diagnostic{Section: "Tax", Severity: broker.Error, Message: "Error calculating tax due: " + err.Error()})
+	}
+	diags.Add(r.unknownSources()...)
+	filings, err := newFilings(l.profits, l.tax, l.rtr, s.municipality)
+	if err != nil {
+		diags.Add(broker.Diagnostic{Section: "Tax", Severity: broker.Error, Message: "Error calculating dividend filings: " + err.Error()})
+	}
+	for _, f := range filings {
+		if f.written(s) {
+			diags.Add(broker.Diagnostic{Section: "JOPPD", Severity: broker.Info, Message: fmt.Sprintf(
+				"%d dividends filed in JOPPD-%s.xml are left out of the yearly report, JOPPD and INO-DOH", len(f.payments), f.period.Format("2006-01"))})
+		}
+	}
+	var rates tax.Rates
+	if cmd == "plan" {
+		if rates, err = tax.For(time.Now().Year(), s.municipality); err != nil {
+			diags.Add(broker.Diagnostic{Section: "Tax", Severity: broker.Error, Message: "Error calculating tax rates: " + err.Error()})
+		}
+	}
+	if s.strict && diags.Count(broker.Warning)+diags.Count(broker.Error) > 0 {
+		_ = printDiagnostics(os.Stdout, diags)
+		log.Fatalln("Stopped in strict mode. Fix the statements or add what is missing, or run without -strict")
+	}
+	defer func() {
+		if err := printDiagnostics(os.Stdout, diags); err != nil {
+			fmt.Println("Error printing diagnostics:", err)
+		}
+	}()
+	switch cmd {
+	case "explain":
+		if err := explain(os.Stdout, r, year, source); err != nil {
+			log.Fatalf("Error explaining %d: %v\n", year, err)
+		}
+		return
+	case "plan":
+		now := time.Now()
+		var realized float64
+		if y, ok := r[now.Year()]; ok {
+			realized = y.realizedPL
+		}
+		options := saleOptions(newHoldings(l.open, l.prices, l.rtr, now), l.rtr, now)
+		if err := plan(os.Stdout, now.Year(), realized, options, rates); err != nil {
+			log.Fatalf("Error planning sales: %v\n", err)
+		}
+		return
+	case "reconcile":
+		if err := writeReconciliation(os.Stdout, reconcile(stmts, l)); err != nil {
+			log.Fatalf("Error reconciling realized profits: %v\n", err)
+		}
+		return
+	case "whatif":
+		if salesFile != "" {
+			fromFile, err := readSales(salesFile)
+			if err != nil {
+				log.Fatalf("Error reading hypothetical sales: %v\n", err)
+			}
+			sales = append(sales, fromFile...)
+		}
+		trades, err := l.syntheticTrades(sales)
+		if err != nil {
+			log.Fatalf("Error creating hypothetical sales: %v\n", err)
+		}
+		var years []int
+		for _, t := range trades {
+			if !slices.Contains(years, t.Time.Year()) {
+				years = append(years, t.Time.Year())
+			}
+		}
+		sort.Ints(years)
+		// The hypothetical sales change no tax rates, so errors calculating them were already diagnosed
+		after, _ := newReport(l.withTrades(trades), s)
+		if err := compareReports(os.Stdout, r, after, years); err != nil {
+			log.Fatalf("Error comparing reports: %v\n", err)
+		}
+		return
+	}
+
+	if err := writeReports(r, strings.Split(s.formats, ",")); err != nil {
 		log.Fatalf("Error writing report: %v\n", err)
 	}
+
+	if s.oib != "" {
+		if err := writeForms(r, s); err != nil {
+			log.Fatalf("Error writing ePorezna forms: %v\n", err)
+		}
+	}
+
+	if len(l.open) > 0 {
+		if err := writeHoldings(newHoldings(l.open, l.prices, l.rtr, time.Now()), time.Now()); err != nil {
+			log.Fatalf("Error writing holdings: %v\n", err)
+		}
+	}
+
+	if len(filings) > 0 {
+		if err := writeFilings(filings, s, time.Now()); err != nil {
+			log.Fatalf("Error writing dividend filings: %v\n", err)
+		}
+		if overdue := overdueFilings(filings, time.Now()); len(overdue) > 0 {
+			fmt.Printf("%d dividend JOPPD filings are past their deadline. See JOPPD-dividende.txt\n", len(overdue))
+		}
+	}
 }
 
+// settings are user provided options affecting the report
+type settings struct {
+	// fxTaxable includes currency gains in taxable profits
+	fxTaxable bool
+	// municipality is the tax administration code of the taxpayer's municipality of residence
+	municipality string
+	// oib and name identify the taxpayer in ePorezna forms
+	oib, name string
+	// formats are the comma-separated report output formats
+	formats string
+	// strict stops the run on warnings and errors reading the statements
+	strict bool
+	// inputs are the directories and files to read statements from
+	inputs []string
+	// residence are the periods of Croatian tax residence. Income outside them is not reported. Empty if always resident
+	residence []broker.Period
+	// brokers are the options of statement readers, by reader name
+	brokers map[string]brokerOptions
+	// adjustments is the file of manual corrections to the statements, if any
+	adjustments string
+}
+
+// statementReaders are the available statement readers by name, as used in the configuration file
+var statementReaders = map[string]broker.StatementReader{
+	"ibkr":    ibkr.Read,
+	"lots":    lots.Read,
+	"revolut": revolut.Read,
+}
+
+// readerOrder is the order statement readers are tried in. The Revolut reader recognizes files by name only, so it is tried last
+var readerOrder = []string{"ibkr", "lots", "revolut"}
+
 // foreign is a representation of capital gains and Tax paid at foreign source in a single Year
 type foreign struct {
 	// gains is the total foreign income received
 	gains float64
 	// taxPaid is the total Tax paid at the foreign source
 	taxPaid float64
+	// creditable is the part of taxPaid credited against Croatian Tax, limited by the treaty rate and the Croatian Tax on the income
+	creditable float64
+	// excess is the Tax withheld over the treaty rate, which may be reclaimed at the source
+	excess float64
+	// payments and taxes are the underlying income and Tax payments, listed as INO-DOH attachments
+	payments, taxes []pl
+	// taxDue is the Croatian Tax left to pay after crediting the Tax paid at the foreign source
+	taxDue float64
 }
 
 // taxYear represents a single year of taxable income to be reported
@@ -54,16 +276,78 @@ type taxYear struct {
 	// realizedPL is the taxable profit from Trades, dividends and interest
 	// matches JOPPD form main input
 	realizedPL float64
+	// income splits realizedPL by the kind of income, as reported in separate JOPPD rows
+	income map[string]float64
 	// foreignIncome serves the entries in INO-DOH form, accounting for income Tax was fully or partially paid at the foreign source
 	foreignIncome map[string]*foreign
+	// currencyGains are gains realized on converting foreign cash, by currency
+	// They are informational unless configured as taxable, in which case they are also included in realizedPL
+	currencyGains map[string]float64
+	// joppd are the profits and losses adding up to realizedPL, before deductions
+	joppd []pl
+	// taxDue is the Tax left to pay on realizedPL, not including foreign income
+	taxDue float64
+	// taxRates are the Tax rates the Tax due is calculated with
+	taxRates tax.Rates
+	// deductible are the expenses deducted from realizedPL
+	deductible float64
+	// statements are the statement files with data for the year
+	statements []string
+	// checksums map the statement files to their SHA-256 checksums
+	checksums map[string]string
+	// details are the ledger entries the year's figures are calculated from
+	details yearDetails
+}
+
+// yearDetails lists every taxable event in a year, to trace the reported figures
+type yearDetails struct {
+	// disposals include sales exempt from Tax
+	disposals []pl
+	// payments are dividends and interest received
+	payments, taxes, fees []pl
+	// adjustments are the manual corrections applied to the year's statements. Corrections without a date apply to all years
+	adjustments []adjustment
 }
 
 type report map[int]*taxYear
 
+// Kinds of taxable income, reported with different JOPPD labels
+const (
+	capitalGain = "capital gain"
+	dividend    = "dividend"
+	interest    = "interest"
+)
+
 type pl struct {
 	amount float64
 	source string
 	year   int
+	// kind is the kind of taxable income
+	kind string
+	// instrument the income was realized on, if any
+	instrument broker.Instrument
+	// date of the payment or sale, if known
+	date time.Time
+	// disposal details the sale the profit was realized on, if any
+	disposal *disposal
+	// original is the amount in the original currency, converted to amount with the rate
+	original float64
+	currency string
+	rate     float64
+	// origins are the statement rows the pl was derived from. Netted bond interest has several
+	origins []broker.Origin
+}
+
+// disposal details the sale of a quantity matched to a single purchase
+type disposal struct {
+	quantity float64
+	acquired time.Time
+	// cost and price are per unit, in the original currency
+	cost, price float64
+	// taxable is false if the instrument was held for more than 2 years
+	taxable bool
+	// lot is the statement row of the purchase matched to the sale
+	lot broker.Origin
 }
 
 // ledger collects all broker data into a single structure to be reported on.
@@ -71,24 +355,53 @@ type pl struct {
 // TODO ledger should not be concerned with the filtering and FIFO strategy. It should only collect data and let another component handle it
 type ledger struct {
 	tax, profits []pl
-	deductible   map[int]float64
+	// exempt are the profits from sales exempt from Tax
+	exempt []pl
+	// fees are the deductible expenses
+	fees []pl
+	// currencyGains are gains realized on foreign cash conversions, with currency as source
+	currencyGains []pl
+	// trades are all trades read, to be matched again with hypothetical sales
+	trades []broker.Trade
+	// open are the purchase lots left after matching sales, with their remaining quantity
+	open []broker.Trade
+	// unmatched are the sales with no purchases left to match, with the quantity sold over the known holdings
+	unmatched []broker.Trade
+	// prices are the last known prices of instruments
+	prices     map[broker.Instrument]broker.Price
+	deductible map[int]float64
+	// statements lists the statement files with data for each year, with their checksums
+	statements map[int]map[string]string
+	// rtr provides the exchange rates used to build the ledger
+	rtr fx.DailyRater
+	// adjustments are the manual corrections applied to the statements
+	adjustments []adjustment
+	// unreduced are the returns of capital paid when no lots were held, so no cost could be lowered
+	unreduced []broker.Tx
+	// residence are the settings the ledger was filtered to tax residence with, to filter trades matched again
+	residence settings
 }
 
-// findFiles looks for .csv files in the current directory tree, while avoiding duplicates
-func findFiles() []string {
+// findFiles looks for .csv files in the directory trees of the inputs, while avoiding duplicates. Inputs may also be files
+// Problems walking the directories are added to the diagnostics
+func findFiles(inputs []string, diags *broker.Diagnostics) []string {
 	files := make(map[string]struct{})
-	err := filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
-		// Only consider csv and xlsx files
-		fmt.Println("Checking", path)
-		if filepath.Ext(path) == ".csv" || filepath.Ext(path) == ".xlsx" {
-			fmt.Println("Found", path)
-			files[path] = struct{}{}
-		}
+	for _, input := range inputs {
+		err := filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				diags.Add(broker.Diagnostic{Origin: broker.Origin{File: path}, Severity: broker.Warning, Message: "Not searched for statements: " + err.Error()})
+				return nil
+			}
+			// Only consider csv and xlsx files
+			if filepath.Ext(path) == ".csv" || filepath.Ext(path) == ".xlsx" {
+				files[path] = struct{}{}
+			}
 
-		return nil
-	})
-	if err != nil {
-		fmt.Println("Error finding files:", err)
+			return nil
+		})
+		if err != nil {
+			diags.Add(broker.Diagnostic{Origin: broker.Origin{File: input}, Severity: broker.Error, Message: "Error finding files: " + err.Error()})
+		}
 	}
 
 	// list
@@ -100,12 +413,12 @@ func findFiles() []string {
 	return list
 }
 
-// readFiles creates a Statement for each provided file
-func readFiles(rdr *broker.Reader, files []string) <-chan *broker.Statement {
+// readFiles creates a Statement for each provided file. Problems reading the files are added to the diagnostics
+func readFiles(rdr *broker.Reader, files []string, diags *broker.Diagnostics) <-chan *broker.Statement {
 	out := make(chan *broker.Statement, len(files))
 
 	wg := &sync.WaitGroup{}
-	workers := runtime.NumCPU() / 2
+	workers := max(1, runtime.NumCPU()/2)
 	wg.Add(workers)
 	// worker pool
 	for i := 0; i < workers; i++ {
@@ -116,11 +429,21 @@ func readFiles(rdr *broker.Reader, files []string) <-chan *broker.Statement {
 					continue
 				}
 
+				origin := broker.Origin{File: file}
 				bs, err := rdr.Read(file)
+				if errors.Is(err, broker.ErrNotRecognized) {
+					diags.Add(broker.Diagnostic{Origin: origin, Severity: broker.Info, Message: "Skipped, not a recognized statement"})
+					continue
+				}
 				if err != nil {
-					fmt.Println("Error reading file:", err)
+					diags.Add(broker.Diagnostic{Origin: origin, Severity: broker.Error, Message: "Could not read file: " + err.Error()})
+					continue
+				}
+				if bs == nil {
+					diags.Add(broker.Diagnostic{Origin: origin, Severity: broker.Info, Message: "Skipped, already read from another file with the same content"})
 					continue
 				}
+				diags.Add(bs.Diagnostics...)
 				out <- bs
 			}
 		}(i)
@@ -133,9 +456,11 @@ func readFiles(rdr *broker.Reader, files []string) <-chan *broker.Statement {
 	return out
 }
 
-func fifo(ts []broker.Trade, r fx.Rater) []pl {
-	var pls []pl
-	for _, ts := range tradesByISIN(ts) {
+// fifo matches sales to purchases of the same instrument, first in first out
+// It returns the taxable profits and the profits exempt from Tax, as the instrument was held for more than 2 years
+// Trades left with some quantity after matching are returned as open lots: purchases not sold yet and sales of more than was bought before
+func fifo(ts []broker.Trade, r fx.Rater) (taxable, exempt []pl, open []broker.Trade) {
+	for _, ts := range tradesByInstrument(ts) {
 		purchase, sale := 0, 0
 		for {
 			// find next sale
@@ -152,130 +477,303 @@ func fifo(ts []broker.Trade, r fx.Rater) []pl {
 				purchase++
 			}
 
+			// No purchases left to match. The rest of the sale stays open, likely bought in a period missing from the statements
 			if purchase == sale {
-				break
+				sale++
+				continue
 			}
 
-			if pl, taxable := profitFromTrades(&ts[purchase], &ts[sale], r); taxable {
-				pls = append(pls, pl)
+			if pl := profitFromTrades(&ts[purchase], &ts[sale], r); pl.disposal.taxable {
+				taxable = append(taxable, pl)
+			} else {
+				exempt = append(exempt, pl)
+			}
+		}
+
+		for _, t := range ts {
+			// Ignore what is left of a lot due to float rounding
+			if math.Abs(t.Quantity) > 1e-9 {
+				open = append(open, t)
 			}
 		}
 	}
 
-	return pls
+	return taxable, exempt, open
+}
+
+// splitOpen splits trades left open after matching into purchase lots and unmatched sales
+func splitOpen(open []broker.Trade) (lots, sales []broker.Trade) {
+	for _, t := range open {
+		if t.Quantity > 0 {
+			lots = append(lots, t)
+		} else {
+			sales = append(sales, t)
+		}
+	}
+	return lots, sales
 }
 
-// tradesByISIN maps trades by ISIN
-func tradesByISIN(ts []broker.Trade) map[string][]broker.Trade {
-	sort.Slice(ts, func(i, j int) bool {
+// tradesByInstrument maps trades by Instrument, in time order
+// The sort is stable, so that the parts of a lot split at the same time keep their order: the part sold before the part held
+func tradesByInstrument(ts []broker.Trade) map[broker.Instrument][]broker.Trade {
+	sort.SliceStable(ts, func(i, j int) bool {
 		return ts[i].Time.Before(ts[j].Time)
 	})
-	grouped := make(map[string][]broker.Trade)
+	grouped := make(map[broker.Instrument][]broker.Trade)
 	for _, t := range ts {
-		grouped[t.ISIN] = append(grouped[t.ISIN], t)
+		grouped[t.Instrument] = append(grouped[t.Instrument], t)
 	}
 	return grouped
 }
 
-// profitFromTrades, returns the pl from a single purchase and sale Trade, as well as a bool indicating if the Trade was taxable
-func profitFromTrades(purchase, sale *broker.Trade, r fx.Rater) (pl, bool) {
+// profitFromTrades, returns the pl from a single purchase and sale Trade, with the disposal details indicating if the Trade was taxable
+func profitFromTrades(purchase, sale *broker.Trade, r fx.Rater) pl {
 	qtyToSell := math.Min(math.Abs(sale.Quantity), math.Abs(purchase.Quantity))
 	purchase.Quantity -= qtyToSell
 	sale.Quantity += qtyToSell
 
 	return pl{
-		amount: qtyToSell * (sale.Price*r.Rate(sale.Currency, sale.Time.Year()) - purchase.Price*r.Rate(purchase.Currency, sale.Time.Year())),
-		year:   sale.Time.Year(),
-		source: purchase.ISIN[:2],
-	}, sale.Time.Before(purchase.Time.AddDate(2, 0, 0))
+		amount:     qtyToSell * (sale.Price*r.Rate(sale.Currency, sale.Time.Year()) - purchase.Price*r.Rate(purchase.Currency, sale.Time.Year())),
+		year:       sale.Time.Year(),
+		source:     purchase.Instrument.Country,
+		kind:       capitalGain,
+		instrument: purchase.Instrument,
+		original:   qtyToSell * (sale.Price - purchase.Price),
+		currency:   sale.Currency,
+		rate:       r.Rate(sale.Currency, sale.Time.Year()),
+		date:       sale.Time,
+		origins:    []broker.Origin{sale.Origin},
+		disposal: &disposal{
+			quantity: qtyToSell,
+			acquired: purchase.Time,
+			cost:     purchase.Price,
+			price:    sale.Price,
+			taxable:  sale.Time.Before(purchase.Time.AddDate(2, 0, 0)),
+			lot:      purchase.Origin,
+		},
+	}
 }
 
-func newLedger(statements <-chan *broker.Statement) *ledger {
+func newLedger(statements []*broker.Statement) *ledger {
 	// Store all in ledger to provide to Tax report all at once
-	l := &ledger{deductible: make(map[int]float64)}
 	rtr := fx.New()
+	l := &ledger{deductible: make(map[int]float64), statements: make(map[int]map[string]string), prices: make(map[broker.Instrument]broker.Price), rtr: rtr}
 	var trades []broker.Trade
-	for stmt := range statements {
+	var bondInterest []pl
+	var conversions []broker.Conversion
+	var rocs []broker.Tx
+	for _, stmt := range statements {
+		l.addStatement(stmt)
 		l.tax = append(l.tax, profitsFromTransactions(stmt.Tax, rtr)...)
-		l.profits = append(l.profits, profitsFromTransactions(stmt.FixedIncome, rtr)...)
-		trades = append(trades, stmt.Trades...)
-		for _, fee := range stmt.Fees {
-			if _, ok := l.deductible[fee.Year]; !ok {
-				l.deductible[fee.Year] = 0
+		for _, tx := range stmt.FixedIncome {
+			if tx.Category == broker.Bond {
+				bondInterest = append(bondInterest, profitsFromTransactions([]broker.Tx{tx}, rtr)...)
+				continue
 			}
-			l.deductible[fee.Year] += rtr.Rate(fee.Currency, fee.Year)
+			l.profits = append(l.profits, profitsFromTransactions([]broker.Tx{tx}, rtr)...)
 		}
+		trades = append(trades, stmt.Trades...)
+		conversions = append(conversions, stmt.Conversions...)
+		rocs = append(rocs, stmt.ReturnOfCapital...)
+		l.addPrices(stmt.Prices)
+		l.addFees(stmt.Fees, rtr)
 	}
 
+	// Coupons may come from a different statement than the accrued interest paid for them
+	l.profits = append(l.profits, netBondInterest(bondInterest)...)
+
+	// Returns of capital are not income. They lower the cost of the lots held
+	trades, l.unreduced = reduceBasis(trades, rocs, rtr)
+
 	// We have all the Trades. Calculate taxable realized profits
-	l.profits = append(l.profits, fifo(trades, rtr)...)
+	taxable, exempt, open := fifo(trades, rtr)
+	l.profits = append(l.profits, taxable...)
+	l.exempt = exempt
+	l.open, l.unmatched = splitOpen(open)
+	l.trades = trades
+	l.currencyGains = currencyGains(conversions, rtr)
 
 	return l
 }
 
+// addPrices keeps the latest price of each instrument. Prices with a currency are preferred on the same date
+func (l *ledger) addPrices(prices []broker.Price) {
+	for _, p := range prices {
+		last, ok := l.prices[p.Instrument]
+		if !ok || p.Date.After(last.Date) || (p.Date.Equal(last.Date) && last.Currency == "") {
+			l.prices[p.Instrument] = p
+		}
+	}
+}
+
+// addFees adds the fees paid, deducted from the year's income in the Croatian currency of the year
+func (l *ledger) addFees(fees []broker.Tx, r fx.Rater) {
+	for _, fee := range fees {
+		l.deductible[fee.Year] += fee.Amount * r.Rate(fee.Currency, fee.Year)
+		l.fees = append(l.fees, profitsFromTransactions([]broker.Tx{fee}, r)...)
+	}
+}
+
+// addStatement records the years a statement has data for
+func (l *ledger) addStatement(stmt *broker.Statement) {
+	add := func(year int) {
+		if _, ok := l.statements[year]; !ok {
+			l.statements[year] = make(map[string]string)
+		}
+		l.statements[year][stmt.Filename] = stmt.Checksum
+	}
+
+	for _, t := range stmt.Trades {
+		add(t.Time.Year())
+	}
+	for _, c := range stmt.Conversions {
+		add(c.Time.Year())
+	}
+	for _, txs := range [][]broker.Tx{stmt.FixedIncome, stmt.Tax, stmt.Fees} {
+		for _, tx := range txs {
+			add(tx.Year)
+		}
+	}
+}
+
 func profitsFromTransactions(txs []broker.Tx, r fx.Rater) []pl {
 	pls := make([]pl, 0, len(txs))
 
 	for _, tx := range txs {
 		rate := r.Rate(tx.Currency, tx.Year)
-		p := pl{amount: tx.Amount * rate, year: tx.Year}
-		if tx.ISIN != "" {
-			p.source = tx.ISIN[:2]
+		kind := dividend
+		if tx.Category == broker.Bond {
+			kind = interest
 		}
-		pls = append(pls, p)
+		pls = append(pls, pl{
+			amount:     tx.Amount * rate,
+			year:       tx.Year,
+			source:     tx.Instrument.Country,
+			kind:       kind,
+			instrument: tx.Instrument,
+			original:   tx.Amount,
+			currency:   tx.Currency,
+			rate:       rate,
+			date:       tx.Date,
+			origins:    []broker.Origin{tx.Origin},
+		})
 	}
 
 	return pls
 }
 
-func newReport(l *ledger) report {
+// netBondInterest nets accrued interest paid against the next interest received from the same bond, in whatever year it is paid
+// The accrued interest paid on purchase is not income, but a part of the first coupon paid back to the seller
+// It is converted at the rate of the coupon it is netted with. Accrued interest never received back stays a loss in its own year
+func netBondInterest(pls []pl) []pl {
+	type key struct {
+		instrument broker.Instrument
+		currency   string
+	}
+	sorted := append([]pl(nil), pls...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].year != sorted[j].year {
+			return sorted[i].year < sorted[j].year
+		}
+		return sorted[i].date.Before(sorted[j].date)
+	})
+
+	paid := make(map[key][]pl)
+	out := make([]pl, 0, len(sorted))
+	for _, p := range sorted {
+		k := key{p.instrument, p.currency}
+		if p.original < 0 {
+			paid[k] = append(paid[k], p)
+			continue
+		}
+
+		// Pay back the accrued interest, oldest first, as far as this payment covers it
+		for len(paid[k]) > 0 && p.original > 0 {
+			a := &paid[k][0]
+			netted := math.Min(p.original, -a.original)
+			p.original -= netted
+			a.original += netted
+			a.amount = a.original * a.rate
+			p.origins = append(p.origins, a.origins...)
+			if a.original == 0 {
+				paid[k] = paid[k][1:]
+			}
+		}
+		p.amount = p.original * p.rate
+		out = append(out, p)
+	}
+
+	for _, p := range sorted {
+		k := key{p.instrument, p.currency}
+		if len(paid[k]) > 0 {
+			out = append(out, paid[k]...)
+			delete(paid, k)
+		}
+	}
+	return out
+}
+
+// newReport creates a report from the ledger. Currency gains are added to taxable profits only if configured as taxable
+// Dividends from abroad are left to their monthly filings only if the filings are written, which needs the taxpayer's details
+// The report is complete even if the tax rates of some years are unknown, with the error returned
+func newReport(l *ledger, s settings) (report, error) {
 	r := make(report)
-	r.withWitholdingTax(l.tax)
-	r.withProfits(l.profits)
+	profits, withholding := l.profits, l.tax
+	if s.oib != "" {
+		// Errors calculating the filings are the tax rate errors returned below
+		filings, _ := newFilings(l.profits, l.tax, l.rtr, s.municipality)
+		profits, withholding = yearlyIncome(profits, withholding, filings, s)
+	}
+	r.withWitholdingTax(withholding)
+	r.withProfits(profits)
+	r.withCurrencyGains(l.currencyGains, s.fxTaxable)
 	r.withDeductibles(l.deductible)
-	return r
+	r.withStatements(l.statements)
+	r.withDetails(l)
+	return r, r.withTaxDue(s.municipality)
 }
 
+// Report types in the order they are listed within a year
+var reportTypes = []string{"JOPPD", "INO-DOH", "Povrat poreza", "Tečajne razlike"}
+
 func (r report) toRows() [][]string {
 	data := make([][]string, 0, len(r))
 	for _, year := range r {
 		yr := strconv.Itoa(year.year)
-		ccy := "EUR"
-		if year.year < 2023 {
-			ccy = "HRK"
-		}
-		data = append(data, []string{yr, ccy, "JOPPD", fmt.Sprintf("%.2f", math.Max(0, year.realizedPL)), "", ""})
+		ccy := year.currency
+		data = append(data, []string{yr, ccy, "JOPPD", fmt.Sprintf("%.2f", math.Max(0, year.realizedPL)), "", "", "", fmt.Sprintf("%.2f", year.taxDue)})
 		for source, f := range year.foreignIncome {
-			data = append(data, []string{yr, ccy, "INO-DOH", fmt.Sprintf("%.2f", f.gains), source, fmt.Sprintf("%.2f", f.taxPaid)})
+			data = append(data, []string{yr, ccy, "INO-DOH", fmt.Sprintf("%.2f", f.gains), source, fmt.Sprintf("%.2f", f.taxPaid), fmt.Sprintf("%.2f", f.creditable), fmt.Sprintf("%.2f", f.taxDue)})
+			if f.excess > 0 {
+				// Hint the excess withholding can be reclaimed at the source
+				data = append(data, []string{yr, ccy, "Povrat poreza", "", source, fmt.Sprintf("%.2f", f.excess), "", ""})
+			}
+		}
+		for currency, gain := range year.currencyGains {
+			data = append(data, []string{yr, ccy, "Tečajne razlike", fmt.Sprintf("%.2f", gain), currency, "", "", ""})
 		}
 	}
 
 	// sort by Year, then report type, then source
-	// JOPPD before INO-DOH
+	// JOPPD before INO-DOH, reclaim hints and currency gains last
 	sort.Slice(data, func(i, j int) bool {
 		if data[i][0] == data[j][0] {
-			if data[i][1] == data[j][1] {
+			if data[i][2] == data[j][2] {
 				return data[i][4] < data[j][4]
 			}
-			return data[i][1] > data[j][1]
+			return slices.Index(reportTypes, data[i][2]) < slices.Index(reportTypes, data[j][2])
 		}
 		return data[i][0] < data[j][0]
 	})
 
 	// With header
-	return append([][]string{{"Godina", "Valuta", "Izvješće", "Dobit", "Izvor prihoda", "Plaćeni porez"}}, data...)
+	return append([][]string{{"Godina", "Valuta", "Izvješće", "Dobit", "Izvor prihoda", "Plaćeni porez", "Priznati porez", "Porez za uplatu"}}, data...)
 }
 
 func (r report) withWitholdingTax(tax []pl) {
 	for _, pl := range tax {
 		// Add Year to report if not present
-		if _, ok := r[pl.year]; !ok {
-			ccy := "EUR"
-			if pl.year < 2023 {
-				ccy = "HRK"
-			}
-			r[pl.year] = &taxYear{year: pl.year, foreignIncome: make(map[string]*foreign), currency: ccy}
-		}
+		r.addYear(pl.year)
 
 		// Add foreign income for the source
 		if _, ok := r[pl.year].foreignIncome[pl.source]; !ok {
@@ -283,98 +781,177 @@ func (r report) withWitholdingTax(tax []pl) {
 		}
 
 		r[pl.year].foreignIncome[pl.source].taxPaid += math.Abs(pl.amount)
+		r[pl.year].foreignIncome[pl.source].taxes = append(r[pl.year].foreignIncome[pl.source].taxes, pl)
 	}
 }
 
 func (r report) withProfits(profits []pl) {
 	for _, pl := range profits {
-		if _, ok := r[pl.year]; !ok {
-			ccy := "EUR"
-			if pl.year < 2023 {
-				ccy = "HRK"
-			}
-			r[pl.year] = &taxYear{year: pl.year, foreignIncome: make(map[string]*foreign), currency: ccy}
-		}
+		r.addYear(pl.year)
 
 		// If this is a profit and Tax was paid at source, add it to foreign income
+		// Income that cannot have a source country stays domestic, even if Tax was paid on other income of unknown source
 		fi := r[pl.year].foreignIncome[pl.source]
-		if pl.amount > 0 && fi != nil && fi.taxPaid > 0 {
+		if pl.amount > 0 && fi != nil && fi.taxPaid > 0 && (pl.source != "" || hasSource(pl)) {
 			fi.gains += pl.amount
+			fi.payments = append(fi.payments, pl)
 		} else {
 			r[pl.year].realizedPL += pl.amount
+			r[pl.year].income[pl.kind] += pl.amount
+			r[pl.year].joppd = append(r[pl.year].joppd, pl)
 		}
 	}
 }
 
+// hasSource reports whether income can come from a source country. Capital gains and income from crypto assets have none
+func hasSource(p pl) bool {
+	return p.kind != capitalGain && p.instrument.Scheme != broker.SchemeCrypto
+}
+
+// withCurrencyGains adds currency gains to the report. If taxable, they are also included in the realized profits
+func (r report) withCurrencyGains(gains []pl, taxable bool) {
+	for _, pl := range gains {
+		r.addYear(pl.year)
+		r[pl.year].currencyGains[pl.source] += pl.amount
+		if taxable {
+			r[pl.year].realizedPL += pl.amount
+			r[pl.year].income[capitalGain] += pl.amount
+			r[pl.year].joppd = append(r[pl.year].joppd, pl)
+		}
+	}
+}
+
+// addYear adds a Tax year to the report if not present
+func (r report) addYear(year int) {
+	if _, ok := r[year]; ok {
+		return
+	}
+	r[year] = &taxYear{
+		year:          year,
+		income:        make(map[string]float64),
+		foreignIncome: make(map[string]*foreign),
+		currencyGains: make(map[string]float64),
+		currency:      fx.Base(year),
+	}
+}
+
+// withTaxDue calculates the Tax left to pay for each year, using capital income Tax and surtax rates of the municipality
+// Tax paid at a foreign source is credited up to the treaty rate and the amount of Croatian Tax on that income
+// Errors are reported once, with the years of unknown surtax rates listed together
+func (r report) withTaxDue(municipality string) error {
+	var errs []error
+	var unknown []string
+	seen := make(map[string]bool)
+	for _, year := range r.sorted() {
+		rates, err := tax.For(year.year, municipality)
+		switch {
+		case errors.Is(err, tax.ErrUnknownSurtax):
+			unknown = append(unknown, strconv.Itoa(year.year))
+		case err != nil && !seen[err.Error()]:
+			seen[err.Error()] = true
+			errs = append(errs, err)
+		}
+
+		year.taxRates = rates
+		year.taxDue = rates.Due(year.realizedPL)
+		for source, f := range year.foreignIncome {
+			f.creditable, f.excess = tax.Credit(source, f.gains, f.taxPaid, rates)
+			f.taxDue = rates.Due(f.gains) - f.creditable
+		}
+	}
+	if len(unknown) > 0 {
+		m, _ := tax.Lookup(municipality)
+		errs = append(errs, fmt.Errorf("%w for %s (%s) in %s", tax.ErrUnknownSurtax, m.Name, m.Code, strings.Join(unknown, ", ")))
+	}
+	return errors.Join(errs...)
+}
+
 func (r report) withDeductibles(deductible map[int]float64) {
 	for yr, amount := range deductible {
 		if year, ok := r[yr]; ok {
+			year.deductible = math.Abs(amount)
 			year.realizedPL -= math.Abs(amount)
+			year.income[capitalGain] -= math.Abs(amount)
 		}
 	}
 
 	// Balance it out. Do not report income if negative
 	// Remove Year from report if no realized PL and no foreign income
 	for _, year := range r {
+		year.offsetLosses()
 		if year.realizedPL <= 0 {
 			year.realizedPL = 0
 
-			if len(year.foreignIncome) == 0 {
+			if len(year.foreignIncome) == 0 && len(year.currencyGains) == 0 {
 				delete(r, year.year)
 			}
 		}
 	}
 }
 
-func writeFile(data [][]string) (err error) {
-	// Calculate column widths
-	widths := colWidths(data)
-
-	// Print tab-separated to file
-	file, err := os.Create("report.txt")
-	if err != nil {
-		return
+// withDetails adds the ledger entries to each year in the report
+func (r report) withDetails(l *ledger) {
+	for _, p := range append(append([]pl{}, l.profits...), l.exempt...) {
+		if year, ok := r[p.year]; ok {
+			if p.disposal != nil {
+				year.details.disposals = append(year.details.disposals, p)
+			} else {
+				year.details.payments = append(year.details.payments, p)
+			}
+		}
 	}
-	defer func() {
-		if fErr := file.Close(); fErr != nil {
-			err = errors.Join(err, fErr)
+	for _, p := range l.tax {
+		if year, ok := r[p.year]; ok {
+			year.details.taxes = append(year.details.taxes, p)
 		}
-	}()
-
-	w := bufio.NewWriter(file)
-	defer func() {
-		if fErr := w.Flush(); fErr != nil {
-			err = errors.Join(err, fErr)
+	}
+	for _, p := range l.fees {
+		if year, ok := r[p.year]; ok {
+			year.details.fees = append(year.details.fees, p)
 		}
-	}()
-	for _, row := range data {
-		for i, cell := range row {
-			// Right-align 'Dobit' and 'Plaćeni porez' columns
-			if i == 3 || i == 5 {
-				cell = strings.Repeat(" ", widths[i]-len(cell)) + cell
-			}
-			_, err = fmt.Fprintf(w, "%-*s", widths[i]+2, cell)
-			if err != nil {
-				return
+	}
+	for _, a := range l.adjustments {
+		for _, year := range r {
+			if a.date.IsZero() || a.date.Year() == year.year {
+				year.details.adjustments = append(year.details.adjustments, a)
 			}
 		}
-		err = w.WriteByte('\n')
-		if err != nil {
-			return
+	}
+
+	for _, year := range r {
+		for _, pls := range [][]pl{year.details.disposals, year.details.payments, year.details.taxes} {
+			sort.SliceStable(pls, func(i, j int) bool {
+				return pls[i].date.Before(pls[j].date)
+			})
 		}
 	}
+}
 
-	return
+// withStatements lists the source statement files for each year in the report
+func (r report) withStatements(statements map[int]map[string]string) {
+	for _, year := range r {
+		year.checksums = statements[year.year]
+		for file := range statements[year.year] {
+			year.statements = append(year.statements, file)
+		}
+		sort.Strings(year.statements)
+	}
 }
 
-func colWidths(data [][]string) []int {
-	widths := make([]int, len(data[0]))
-	for _, row := range data {
-		for i, cell := range row {
-			if len(cell) > widths[i] {
-				widths[i] = len(cell)
-			}
+// offsetLosses offsets losses in one kind of income against profits in other kinds, so that no kind is reported as negative
+// The income by kind then adds up to realizedPL, if positive
+func (y *taxYear) offsetLosses() {
+	var loss float64
+	for kind, amount := range y.income {
+		if amount < 0 {
+			loss -= amount
+			y.income[kind] = 0
 		}
 	}
-	return widths
+
+	for _, kind := range []string{capitalGain, interest, dividend} {
+		offset := math.Min(loss, y.income[kind])
+		y.income[kind] -= offset
+		loss -= offset
+	}
 }
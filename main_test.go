@@ -0,0 +1,943 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"ibkr-report/broker"
+	"ibkr-report/ibkr"
+	"ibkr-report/lots"
+	"ibkr-report/tax"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixedRater returns the same rate for every currency and date
+type fixedRater float64
+
+func (r fixedRater) Rate(string, int) float64 { return float64(r) }
+
+func (r fixedRater) RateOn(string, time.Time) float64 { return float64(r) }
+
+// currencyRater returns a rate per currency, the same for every date
+type currencyRater map[string]float64
+
+func (r currencyRater) Rate(currency string, _ int) float64 { return r[currency] }
+
+func Test_addFees(t *testing.T) {
+	l := &ledger{deductible: make(map[int]float64)}
+	l.addFees([]broker.Tx{
+		{Currency: "USD", Amount: -10, Year: 2023, Date: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{Currency: "EUR", Amount: -5, Year: 2023, Date: time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{Currency: "USD", Amount: -4, Year: 2022, Date: time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)},
+	}, currencyRater{"USD": 0.9, "EUR": 1})
+
+	// Fees are converted to the Croatian currency, not summed in their own
+	if math.Abs(l.deductible[2023]+14) > 1e-9 || math.Abs(l.deductible[2022]+3.6) > 1e-9 {
+		t.Errorf("unexpected deductible fees: %v", l.deductible)
+	}
+	if len(l.fees) != 3 || l.fees[0].amount != -9 {
+		t.Errorf("unexpected fees: %+v", l.fees)
+	}
+
+	// Only fees paid while resident are deductible, even within a year of residence
+	l.withResidence(settings{residence: []broker.Period{{From: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)}}})
+	if len(l.deductible) != 1 || l.deductible[2023] != -5 {
+		t.Errorf("unexpected deductible fees while resident: %v", l.deductible)
+	}
+}
+
+func Test_netBondInterest(t *testing.T) {
+	bond := broker.ISIN("US91282CEQ0")
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	pls := netBondInterest([]pl{
+		{amount: 25, original: 25, rate: 1, year: 2024, date: day(2024, 1, 31), kind: interest, instrument: bond, currency: "USD"},
+		{amount: -20, original: -20, rate: 0.9, year: 2023, date: day(2023, 12, 5), kind: interest, instrument: bond, currency: "USD"},
+		{amount: 25, original: 25, rate: 1, year: 2024, date: day(2024, 7, 31), kind: interest, instrument: bond, currency: "USD"},
+	})
+
+	if len(pls) != 2 {
+		t.Fatalf("expected accrued interest netted against the next coupon, got %+v", pls)
+	}
+	if pls[0].year != 2024 || math.Abs(pls[0].amount-5) > 1e-9 || !pls[0].date.Equal(day(2024, 1, 31)) {
+		t.Errorf("expected the January coupon less the accrued interest paid in December, got %+v", pls[0])
+	}
+	if pls[1].amount != 25 {
+		t.Errorf("expected the next coupon in full, got %+v", pls[1])
+	}
+}
+
+func Test_currencyGains(t *testing.T) {
+	day := func(m, d int) time.Time { return time.Date(2023, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	cs := []broker.Conversion{
+		// Convert back to EUR first, to make sure conversions are sorted
+		{Time: day(6, 1), From: "USD", To: "EUR", Sold: 1500, Bought: 1500 / 1.2},
+		{Time: day(1, 1), From: "EUR", To: "USD", Sold: 1000, Bought: 1000},
+		{Time: day(2, 1), From: "EUR", To: "USD", Sold: 900, Bought: 1000},
+	}
+
+	pls := currencyGains(cs, fixedRater(1))
+	if len(pls) != 1 {
+		t.Fatalf("expected a single currency gain, got %d", len(pls))
+	}
+
+	// 1000 USD bought at 1 EUR and 500 USD at 0.9 EUR, sold at 1/1.2 EUR
+	want := 1000*(1/1.2-1) + 500*(1/1.2-0.9)
+	if pls[0].source != "USD" || pls[0].year != 2023 || math.Abs(pls[0].amount-want) > 1e-9 {
+		t.Errorf("currencyGains() = %+v; want %.4f USD gain in 2023", pls[0], want)
+	}
+}
+
+func Test_newLedger_fees(t *testing.T) {
+	stmts := []*broker.Statement{{Fees: []broker.Tx{{Currency: "EUR", Amount: -10, Year: 2023}}}}
+
+	// The fee amount is deducted, not the exchange rate of its currency
+	if l := newLedger(stmts); l.deductible[2023] != -10 {
+		t.Errorf("deductible fees = %v; want -10", l.deductible[2023])
+	}
+}
+
+func Test_filingDeadline(t *testing.T) {
+	tests := []struct {
+		period, deadline string
+	}{
+		{"2023-05-01", "2023-06-15"},
+		// 15th on Saturday and Sunday
+		{"2023-06-01", "2023-07-17"},
+		{"2023-09-01", "2023-10-16"},
+		{"2023-12-01", "2024-01-15"},
+	}
+
+	for _, tt := range tests {
+		period, _ := time.Parse("2006-01-02", tt.period)
+		if got := filingDeadline(period).Format("2006-01-02"); got != tt.deadline {
+			t.Errorf("filingDeadline(%s) = %s; want %s", tt.period, got, tt.deadline)
+		}
+	}
+}
+
+func Test_newFilings(t *testing.T) {
+	us := broker.ISIN("US0378331005")
+	day := func(m, d int) time.Time { return time.Date(2024, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	dividends := []pl{
+		{kind: dividend, source: "US", instrument: us, date: day(5, 16), original: 100, currency: "USD"},
+		{kind: dividend, source: "US", instrument: us, date: day(5, 30), original: 100, currency: "USD"},
+		{kind: dividend, source: "US", instrument: us, date: day(8, 15), original: 100, currency: "USD"},
+		// Unknown date, domestic and interest payments are not filed per payment
+		{kind: dividend, source: "US", instrument: us, original: 100, currency: "USD"},
+		{kind: dividend, source: "HR", instrument: broker.ISIN("HRHT00RA0005"), date: day(5, 16), original: 100, currency: "EUR"},
+		{kind: interest, source: "US", instrument: us, date: day(5, 16), original: 100, currency: "USD"},
+	}
+	withholding := []pl{{source: "US", instrument: us, date: day(5, 16), original: -30, currency: "USD"}}
+
+	filings, err := newFilings(dividends, withholding, fixedRater(1), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filings) != 2 {
+		t.Fatalf("expected 2 filings, got %d", len(filings))
+	}
+
+	may := filings[0]
+	if len(may.payments) != 2 || may.income != 200 || may.taxPaid != 30 || may.deadline != day(6, 17) {
+		t.Errorf("unexpected May filing: %+v", may)
+	}
+	// 12% on 200, with 12% of the first payment credited
+	if math.Abs(may.taxDue-12) > 1e-9 {
+		t.Errorf("May filing tax due = %v; want 12", may.taxDue)
+	}
+	if overdue := overdueFilings(filings, day(7, 1)); len(overdue) != 1 || overdue[0].period != day(5, 1) {
+		t.Errorf("expected May filing overdue on July 1st, got %+v", overdue)
+	}
+
+	// Without the taxpayer's details no filing is written, and the dividends stay in the yearly reports
+	for i := range dividends {
+		dividends[i].amount, dividends[i].year = dividends[i].original, 2024
+	}
+	withholding[0].amount, withholding[0].year = withholding[0].original, 2024
+	l := &ledger{profits: dividends, tax: withholding, deductible: make(map[int]float64), rtr: fixedRater(1)}
+	r, err := newReport(l, settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if us := r[2024].foreignIncome["US"]; us == nil || us.gains != 500 || us.taxPaid != 30 {
+		t.Errorf("expected the dividends in INO-DOH, got %+v", us)
+	}
+
+	// The dividends in the filings written and the Tax withheld from them are left out of the yearly reports
+	s := settings{oib: "69435151530"}
+	profits, withheld := yearlyIncome(dividends, withholding, filings, s)
+	if len(profits) != 3 || len(withheld) != 0 {
+		t.Errorf("expected 3 payments and no Tax reported yearly, got %+v and %+v", profits, withheld)
+	}
+	if r, err = newReport(l, s); err != nil {
+		t.Fatal(err)
+	}
+	incomes, err := r[2024].joppdIncomes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, in := range incomes {
+		total += in.Amount
+	}
+	if total != 300 || r[2024].realizedPL != total || len(r[2024].foreignIncome) != 0 {
+		t.Errorf("JOPPD rows add up to %v, realized profit is %v; want 300 and no INO-DOH", total, r[2024].realizedPL)
+	}
+}
+
+func Test_reportWriters(t *testing.T) {
+	r := make(report)
+	r.addYear(2023)
+	r[2023].realizedPL = 1000
+	r[2023].income[capitalGain] = 1000
+	r[2023].foreignIncome["US"] = &foreign{gains: 100, taxPaid: 15, creditable: 12}
+	r[2023].statements = []string{"2023.csv"}
+
+	var buf bytes.Buffer
+	if err := (jsonWriter{}).write(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	var years []jsonYear
+	if err := json.Unmarshal(buf.Bytes(), &years); err != nil {
+		t.Fatal(err)
+	}
+	if len(years) != 1 || years[0].RealizedPL != 1000 || years[0].ForeignIncome["US"].TaxPaid != 15 || years[0].Statements[0] != "2023.csv" {
+		t.Errorf("unexpected JSON report: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := (csvWriter{}).write(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[1][2] != "JOPPD" || rows[2][4] != "US" {
+		t.Errorf("unexpected CSV report: %v", rows)
+	}
+
+	buf.Reset()
+	if err := (htmlWriter{}).write(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	if html := buf.String(); !strings.Contains(html, "<h2>2023") || strings.Contains(html, "<script src") {
+		t.Errorf("unexpected HTML report: %s", html)
+	}
+
+	buf.Reset()
+	if err := (pdfWriter{}).write(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")) {
+		t.Errorf("unexpected PDF report: %q", buf.String()[:min(buf.Len(), 20)])
+	}
+}
+
+func Test_withTaxDue(t *testing.T) {
+	r := make(report)
+	r.addYear(2015)
+	r.addYear(2016)
+	err := r.withTaxDue("01333")
+	if !errors.Is(err, tax.ErrUnknownSurtax) || strings.Count(err.Error(), "\n") != 0 || !strings.Contains(err.Error(), "in 2015, 2016") {
+		t.Errorf("expected the years of unknown surtax rates in a single error, got %v", err)
+	}
+
+	err = r.withTaxDue("00000")
+	if !errors.Is(err, tax.ErrUnknownMunicipality) || strings.Count(err.Error(), "\n") != 0 {
+		t.Errorf("expected an unknown municipality reported once, got %v", err)
+	}
+}
+
+func Test_unknownSources(t *testing.T) {
+	r := make(report)
+	r.addYear(2023)
+	r[2023].foreignIncome["US"] = &foreign{gains: 100, taxPaid: 15}
+	r[2023].foreignIncome[""] = &foreign{taxPaid: 5}
+	r.withProfits([]pl{
+		{amount: 50, year: 2023, kind: dividend, instrument: broker.Instrument{ID: "ABC", Scheme: broker.SchemeSymbol}, origins: []broker.Origin{{File: "2023.csv", Line: 7}}},
+		{amount: 30, year: 2023, kind: capitalGain, instrument: broker.CryptoAsset("BTC")},
+		{amount: 20, year: 2023, kind: dividend, instrument: broker.CryptoAsset("ETH")},
+	})
+
+	if len(r[2023].joppd) != 2 || r[2023].realizedPL != 50 {
+		t.Errorf("expected gains and crypto income without a source country in JOPPD, got %+v", r[2023].joppd)
+	}
+
+	if incomes := r[2023].inodohIncomes(); len(incomes) != 1 || incomes[0].Country != "US" {
+		t.Errorf("expected income from an unknown source left out of INO-DOH, got %+v", incomes)
+	}
+	if diags := r.unknownSources(); len(diags) != 1 || diags[0].Origin.Line != 7 || !strings.Contains(diags[0].Message, "ABC") {
+		t.Errorf("expected income from an unknown source diagnosed, got %v", diags)
+	}
+}
+
+func Test_sourceBars(t *testing.T) {
+	r := make(report)
+	r.addYear(2022)
+	r.addYear(2023)
+	r[2022].details.payments = []pl{{amount: 753.45, source: "US"}}
+	r[2023].details.payments = []pl{{amount: 100, source: "US"}, {amount: 10}}
+
+	r[2022].realizedPL = 753.45
+	r[2023].realizedPL = 100
+	if bars := yearBars(r.sorted()); len(bars) != 2 || bars[0].Label != "2022" || math.Abs(bars[0].Value-100) > 1e-9 || bars[1].Value != 100 {
+		t.Errorf("expected yearly profits in EUR, got %+v", bars)
+	}
+
+	bars := sourceBars(r.sorted())
+	if len(bars) != 2 || bars[0].Label != "?" || bars[1].Label != "US" || math.Abs(bars[1].Value-200) > 1e-9 {
+		t.Errorf("expected HRK converted to EUR, got %+v", bars)
+	}
+}
+
+func Test_explain(t *testing.T) {
+	us := broker.ISIN("US0378331005")
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	purchase := broker.Trade{Instrument: us, Time: day(2022, 3, 1), Currency: "USD", Quantity: 10, Price: 100, Origin: broker.Origin{File: "2022.csv", Line: 40}}
+	sale := broker.Trade{Instrument: us, Time: day(2023, 3, 1), Currency: "USD", Quantity: -10, Price: 150, Origin: broker.Origin{File: "2023.csv", Line: 12}}
+	taxable, _, _ := fifo([]broker.Trade{purchase, sale}, fixedRater(1))
+
+	l := &ledger{
+		profits: append(taxable, pl{amount: 100, original: 100, currency: "USD", rate: 1, year: 2023, source: "US", kind: dividend, instrument: us,
+			date: day(2023, 5, 16), origins: []broker.Origin{{File: "2023.csv", Line: 80}}}),
+		tax: []pl{{amount: -15, original: -15, currency: "USD", rate: 1, year: 2023, source: "US", instrument: us,
+			date: day(2023, 5, 16), origins: []broker.Origin{{File: "2023.csv", Line: 90}}}},
+		deductible: make(map[int]float64),
+		statements: make(map[int]map[string]string),
+	}
+	r, err := newReport(l, settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := explain(&buf, r, 2023, ""); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"2023.csv:12", "kupljeno 2022-03-01 (2022.csv:40)", "500.00", "INO-DOH US", "2023.csv:80", "2023.csv:90"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("explanation is missing %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	if err := explain(&buf, r, 2023, "DE"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "US0378331005") {
+		t.Errorf("explanation for DE lists US income:\n%s", buf.String())
+	}
+}
+
+func Test_newHoldings(t *testing.T) {
+	us := broker.ISIN("US0378331005")
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	trades := []broker.Trade{
+		{Instrument: us, Time: day(2022, 3, 1), Currency: "USD", Quantity: 10, Price: 100},
+		{Instrument: us, Time: day(2023, 3, 1), Currency: "USD", Quantity: 10, Price: 200},
+		{Instrument: us, Time: day(2023, 6, 1), Currency: "USD", Quantity: -15, Price: 150},
+	}
+	_, _, open := fifo(trades, fixedRater(1))
+	if len(open) != 1 || open[0].Quantity != 5 || open[0].Price != 200 {
+		t.Fatalf("expected 5 of the second lot open, got %+v", open)
+	}
+
+	prices := map[broker.Instrument]broker.Price{us: {Instrument: us, Currency: "USD", Price: 180, Date: day(2023, 12, 31)}}
+	holdings := newHoldings(open, prices, fixedRater(1), day(2024, 2, 1))
+	h := holdings[0]
+	if h.cost != 1000 || h.value != 900 || h.unrealized() != -100 {
+		t.Errorf("unexpected holding value: %+v", h)
+	}
+	if days := h.daysToExemption(day(2024, 2, 1)); days != 394 {
+		t.Errorf("daysToExemption() = %d; want 394", days)
+	}
+	if days := h.daysToExemption(day(2025, 5, 1)); days != 0 {
+		t.Errorf("daysToExemption() after exemption = %d; want 0", days)
+	}
+}
+
+func Test_harvest(t *testing.T) {
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	lot := func(isin string, acquired time.Time, cost, price float64) holding {
+		ins := broker.ISIN(isin)
+		return holding{
+			lot:    broker.Trade{Instrument: ins, Time: acquired, Currency: "EUR", Quantity: 10, Price: cost},
+			exempt: acquired.AddDate(2, 0, 0),
+			price:  broker.Price{Instrument: ins, Price: price},
+		}
+	}
+	now := day(2024, 10, 1)
+	holdings := []holding{
+		lot("US0000000001", day(2024, 1, 10), 100, 90), // -100
+		lot("US0000000002", day(2023, 6, 1), 100, 70),  // -300
+		lot("US0000000003", day(2021, 6, 1), 100, 50),  // -500, exempt
+		lot("US0000000004", day(2024, 2, 1), 100, 120), // +200
+		lot("US0000000005", day(2024, 2, 1), 100, 0),   // no price
+	}
+
+	options := saleOptions(holdings, fixedRater(1), now)
+	if len(options) != 4 {
+		t.Fatalf("expected 4 sale options, got %d", len(options))
+	}
+	if options[2].pl.disposal.taxable {
+		t.Errorf("lot held since 2021 should be exempt: %+v", options[2].pl)
+	}
+
+	picked := harvest(options, 250)
+	if len(picked) != 1 || picked[0].lot.Instrument.ID != "US0000000002" {
+		t.Errorf("expected the largest taxable loss to offset 250, got %+v", picked)
+	}
+	if picked := harvest(options, 350); len(picked) != 2 {
+		t.Errorf("expected both taxable losses to offset 350, got %d", len(picked))
+	}
+	if picked := harvest(options, 0); len(picked) != 0 {
+		t.Errorf("expected no suggestions without realized gains, got %d", len(picked))
+	}
+}
+
+func Test_whatif(t *testing.T) {
+	us := broker.ISIN("US0378331005")
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	purchase := broker.Trade{Instrument: us, Time: day(2024, 1, 10), Currency: "USD", Quantity: 10, Price: 100}
+	dividendPL := pl{amount: 50, year: 2024, kind: dividend, instrument: us}
+	l := &ledger{
+		trades:     []broker.Trade{purchase},
+		profits:    []pl{dividendPL},
+		open:       []broker.Trade{purchase},
+		deductible: make(map[int]float64),
+		statements: make(map[int]map[string]string),
+		rtr:        fixedRater(1),
+	}
+
+	sale, err := parseSale([]string{"US0378331005", "4", "2024-12-20", "150"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseSale([]string{"US0378331005", "-4", "2024-12-20", "150"}); err == nil {
+		t.Error("expected an error for a negative quantity")
+	}
+
+	trades, err := l.syntheticTrades([]hypotheticalSale{sale})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trades[0].Instrument != us || trades[0].Currency != "USD" || trades[0].Quantity != -4 {
+		t.Errorf("unexpected synthetic trade: %+v", trades[0])
+	}
+	if _, err := l.syntheticTrades([]hypotheticalSale{{id: "US5949181045", quantity: 1}}); err == nil {
+		t.Error("expected an error for an instrument never bought")
+	}
+
+	before, _ := newReport(l, settings{})
+	after, _ := newReport(l.withTrades(trades), settings{})
+	if got := after[2024].realizedPL; got != 250 {
+		t.Errorf("realizedPL after sale = %v; want 250", got)
+	}
+	if len(l.trades) != 1 || len(l.profits) != 1 || l.open[0].Quantity != 10 {
+		t.Errorf("original ledger changed: %+v", l)
+	}
+
+	var buf bytes.Buffer
+	if err := compareReports(&buf, before, after, []int{2024}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "200.00") {
+		t.Errorf("expected a JOPPD change of 200:\n%s", buf.String())
+	}
+
+	// A sale after moving abroad is not taxed in Croatia
+	l.withResidence(settings{residence: []broker.Period{{From: day(2020, 1, 1), To: day(2024, 6, 30)}}})
+	if after, _ = newReport(l.withTrades(trades), settings{}); after[2024].realizedPL != 50 {
+		t.Errorf("realizedPL after sale outside residence = %v; want 50", after[2024].realizedPL)
+	}
+}
+
+func Test_deduplicate(t *testing.T) {
+	period := func(from, to string) broker.Period {
+		f, _ := time.Parse("2006-01-02", from)
+		tt, _ := time.Parse("2006-01-02", to)
+		return broker.Period{From: f, To: tt}
+	}
+	trade := broker.Trade{Instrument: broker.ISIN("US0378331005"), Time: time.Date(2023, 2, 1, 10, 0, 0, 0, time.UTC), Quantity: 1, Price: 100}
+
+	yearly := &broker.Statement{Filename: "2023.csv", Account: "U1", Period: period("2023-01-01", "2023-12-31"), Trades: []broker.Trade{trade}}
+	monthly := &broker.Statement{Filename: "2023-02.csv", Account: "U1", Period: period("2023-02-01", "2023-02-28"), Trades: []broker.Trade{trade}}
+	other := &broker.Statement{Filename: "other.csv", Account: "U2", Period: period("2023-02-01", "2023-02-28")}
+
+	stmts, dropped, err := deduplicate([]*broker.Statement{monthly, other, yearly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 2 || dropped["2023-02.csv"] != "2023.csv" {
+		t.Errorf("expected the monthly statement dropped, got %d statements and %v", len(stmts), dropped)
+	}
+
+	partial := &broker.Statement{Filename: "2023-2024.csv", Account: "U1", Period: period("2023-07-01", "2024-06-30")}
+	if _, _, err := deduplicate([]*broker.Statement{yearly, partial}); err == nil || !strings.Contains(err.Error(), "2023-2024.csv") {
+		t.Errorf("expected partially overlapping statements reported, got %v", err)
+	}
+
+	// Without period information, identical trades give duplicates away
+	copied := &broker.Statement{Filename: "copy.csv", Trades: []broker.Trade{trade, trade}}
+	if _, _, err := deduplicate([]*broker.Statement{yearly, copied}); err == nil || !strings.Contains(err.Error(), "1 identical trades") {
+		t.Errorf("expected identical trades reported, got %v", err)
+	}
+}
+
+func Test_coverageWarnings(t *testing.T) {
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	us := broker.ISIN("US0378331005")
+	trades := []broker.Trade{
+		{Instrument: us, Time: day(2023, 3, 1), Quantity: 5, Price: 100},
+		{Instrument: us, Time: day(2023, 6, 1), Quantity: -8, Price: 120, Origin: broker.Origin{File: "2023.csv", Line: 20}},
+		// Later trades are still matched
+		{Instrument: us, Time: day(2023, 7, 1), Quantity: 2, Price: 100},
+		{Instrument: us, Time: day(2023, 8, 1), Quantity: -2, Price: 130},
+	}
+	taxable, _, open := fifo(trades, fixedRater(1))
+	lots, unmatched := splitOpen(open)
+	if len(taxable) != 2 || len(lots) != 0 || len(unmatched) != 1 || unmatched[0].Quantity != -3 {
+		t.Fatalf("unexpected matching: %d profits, open %+v, unmatched %+v", len(taxable), lots, unmatched)
+	}
+
+	stmts := []*broker.Statement{
+		{Filename: "2021.csv", Account: "U1", Period: broker.Period{From: day(2021, 1, 1), To: day(2021, 12, 31)}},
+		{Filename: "2023.csv", Account: "U1", Period: broker.Period{From: day(2023, 1, 1), To: day(2023, 12, 31)}},
+	}
+	warnings := coverageWarnings(stmts, unmatched)
+	if len(warnings) != 2 {
+		t.Fatalf("expected a gap and a sale warning, got %v", warnings)
+	}
+	if !strings.Contains(warnings[0].String(), "2022-01-01 - 2022-12-31") {
+		t.Errorf("expected a gap in 2022, got %s", warnings[0])
+	}
+	if !strings.Contains(warnings[1].String(), "2023.csv:20") || !strings.Contains(warnings[1].String(), "by 3") || !strings.Contains(warnings[1].String(), "2022-01-01 - 2022-12-31 is likely missing") {
+		t.Errorf("unexpected sale warning: %s", warnings[1])
+	}
+}
+
+func Test_transferWarnings(t *testing.T) {
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	us := broker.ISIN("US037833100")
+	stmts := []*broker.Statement{
+		{Broker: "IBKR", Account: "U1", Filename: "2023.csv", Transfers: []broker.Transfer{
+			{Instrument: us, Time: day(2023, 2, 1), Quantity: 10, Origin: broker.Origin{File: "2023.csv", Line: 40}},
+			{Instrument: us, Time: day(2023, 5, 3), Quantity: 5},
+		}},
+		{Broker: "Revolut", Filename: "revolut-2023.csv", Transfers: []broker.Transfer{
+			// Arrives 2 days later at IBKR
+			{Instrument: us, Time: day(2023, 5, 1), Quantity: -5},
+			{Instrument: us, Time: day(2023, 6, 1), Quantity: -2, Origin: broker.Origin{File: "revolut-2023.csv", Line: 7}},
+		}},
+		{Broker: lots.Broker, Filename: "lots.csv", Trades: []broker.Trade{
+			{Instrument: us, Time: day(2020, 5, 1), Quantity: 6, Price: 80},
+		}},
+	}
+	pairs, in, out := matchTransfers(stmts)
+	if len(pairs) != 1 || pairs[0].in.Time != day(2023, 5, 3) || len(in) != 1 || len(out) != 1 {
+		t.Fatalf("unexpected matching: pairs %+v, in %+v, out %+v", pairs, in, out)
+	}
+
+	warnings := transferWarnings(stmts)
+	if len(warnings) != 2 {
+		t.Fatalf("expected warnings for the missing basis and the unmatched transfer out, got %v", warnings)
+	}
+	if !strings.Contains(warnings[0].String(), "Transfer of 4 US037833100") || !strings.Contains(warnings[0].String(), "2023.csv:40") || !strings.Contains(warnings[0].String(), "US037833100,<acquisition date>,4,") {
+		t.Errorf("unexpected basis warning: %s", warnings[0])
+	}
+	if !strings.Contains(warnings[1].String(), "Transfer of 2 US037833100 out") || !strings.Contains(warnings[1].String(), "revolut-2023.csv:7") {
+		t.Errorf("unexpected transfer out warning: %s", warnings[1])
+	}
+
+	stmts[2].Trades = append(stmts[2].Trades, broker.Trade{Instrument: us, Time: day(2021, 5, 1), Quantity: 4, Price: 90})
+	if warnings := transferWarnings(stmts); len(warnings) != 1 {
+		t.Errorf("expected only the transfer out warning with opening lots covering the transfer in, got %v", warnings)
+	}
+}
+
+func Test_reconcile(t *testing.T) {
+	day := func(m, d int) time.Time { return time.Date(2023, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	us, de := broker.ISIN("US037833100"), broker.ISIN("DE000BASF11")
+	buy := broker.Origin{File: "2023.csv", Line: 10}
+	sell := broker.Origin{File: "2023.csv", Line: 11}
+	stmt := &broker.Statement{
+		Filename: "2023.csv",
+		Trades: []broker.Trade{
+			// A purchase split in two lots by a return of capital
+			{Instrument: us, Time: day(1, 5), Currency: "EUR", Quantity: 5, Price: 100, Origin: buy},
+			{Instrument: us, Time: day(1, 5), Currency: "EUR", Quantity: 5, Price: 100, Origin: buy},
+			{Instrument: us, Time: day(3, 5), Currency: "EUR", Quantity: -5, Price: 120, Origin: sell},
+			{Instrument: de, Time: day(4, 5), Currency: "EUR", Quantity: 2, Price: 50},
+			{Instrument: de, Time: day(5, 5), Currency: "EUR", Quantity: -2, Price: 40, Origin: broker.Origin{File: "2023.csv", Line: 13}},
+		},
+		Fees: []broker.Tx{{Currency: "EUR", Amount: -2, Origin: buy}, {Currency: "EUR", Amount: -1, Origin: sell}},
+		Realized: []broker.Realized{
+			// 5 * (120 - 100) - 1 - 2 * 5/10
+			{Instrument: us, Symbol: "AAPL", Currency: "EUR", Amount: 98},
+			// An option assignment missing from the trades
+			{Instrument: broker.Instrument{ID: "AAPL 230317C00150000"}, Symbol: "AAPL 230317C00150000", Currency: "EUR", Amount: 35},
+		},
+	}
+	l := &ledger{trades: stmt.Trades, rtr: fixedRater(1)}
+	taxable, _, _ := fifo(append([]broker.Trade{}, l.trades...), l.rtr)
+	l.profits = taxable
+
+	rs := reconcile([]*broker.Statement{stmt}, l)
+	if len(rs) != 3 {
+		t.Fatalf("expected 3 instruments, got %+v", rs)
+	}
+	if rs[0].symbol != "AAPL" || math.Abs(rs[0].matched-98) > 1e-9 || rs[0].mismatch() {
+		t.Errorf("unexpected AAPL reconciliation: %+v", rs[0])
+	}
+	if !rs[1].mismatch() || rs[1].reported != 35 || rs[1].matched != 0 {
+		t.Errorf("expected the option to be missing from the trades: %+v", rs[1])
+	}
+	if rs[2].symbol != "DE000BASF11" || !rs[2].mismatch() || rs[2].matched != -20 {
+		t.Errorf("expected the sale unknown to the broker to mismatch: %+v", rs[2])
+	}
+	if mismatches(rs) != 2 {
+		t.Errorf("expected 2 mismatches, got %d", mismatches(rs))
+	}
+
+	var buf bytes.Buffer
+	if err := writeReconciliation(&buf, rs); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Provjeriti") || !strings.Contains(buf.String(), "2 instrumenata") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func Test_printDiagnostics(t *testing.T) {
+	diags := &broker.Diagnostics{}
+	diags.Add(
+		broker.Diagnostic{Origin: broker.Origin{File: "b.csv", Line: 3}, Section: "Trades", Severity: broker.Error, Message: `invalid Quantity "x", read as 0`},
+		broker.Diagnostic{Origin: broker.Origin{File: "a.csv"}, Severity: broker.Info, Message: "Skipped, not a recognized statement"},
+		broker.Diagnostic{Severity: broker.Warning, Message: "No statements for account U1 from 2022-01-01 - 2022-12-31"},
+	)
+
+	var buf bytes.Buffer
+	if err := printDiagnostics(&buf, diags); err != nil {
+		t.Fatal(err)
+	}
+	want := "Warning: No statements for account U1 from 2022-01-01 - 2022-12-31\n" +
+		"Info: a.csv Skipped, not a recognized statement\n" +
+		"Error: b.csv:3 [Trades] invalid Quantity \"x\", read as 0\n" +
+		"Diagnostics: 1 errors, 1 warnings\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func Test_settings_withConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	content := `{
+		"taxpayer": {"oib": "12345678903", "name": "Ana Horvat", "municipality": "01333"},
+		"residence": [{"from": "2020-03-01", "to": "2022-06-30"}, {"from": "2024-01-01"}],
+		"formats": ["txt", "pdf"],
+		"brokers": {"revolut": {"disabled": true}}
+	}`
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := readConfig(filename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	var s settings
+	fs.StringVar(&s.name, "name", "", "")
+	fs.StringVar(&s.formats, "format", "txt", "")
+	if err := fs.Parse([]string{"-name", "Ivo Ivić"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.withConfig(c, fs); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if s.name != "Ivo Ivić" || s.oib != "12345678903" || s.formats != "txt,pdf" || len(s.inputs) != 1 || len(s.readers()) != 2 {
+		t.Errorf("unexpected settings: %+v", s)
+	}
+
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	for _, tt := range []struct {
+		date     time.Time
+		year     int
+		resident bool
+	}{
+		{day(2020, 2, 28), 2020, false},
+		{day(2020, 3, 1), 2020, true},
+		{day(2023, 1, 1), 2023, false},
+		{time.Time{}, 2023, false},
+		{time.Time{}, 2022, true},
+		{day(2030, 1, 1), 2030, true},
+	} {
+		if got := s.resident(tt.date, tt.year); got != tt.resident {
+			t.Errorf("resident(%v, %d) = %v; want %v", tt.date, tt.year, got, tt.resident)
+		}
+	}
+
+	if err := os.WriteFile(filename, []byte(`{"taxpayer": {"oib": "1", "municipality": "1333"}, "brokers": {"finax": {}}, "residence": [{"from": "2020"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = readConfig(filename, true); err != nil {
+		t.Fatal(err)
+	}
+	s = settings{formats: "txt"}
+	if err := errors.Join(s.withConfig(c, fs), s.validate()); err == nil || !strings.Contains(err.Error(), "residence start") || !strings.Contains(err.Error(), "unknown broker") || !strings.Contains(err.Error(), "invalid taxpayer") || !strings.Contains(err.Error(), "invalid municipality") {
+		t.Errorf("expected invalid settings, got %v", err)
+	}
+	// Municipalities without known surtax rates are accepted
+	if err := (settings{formats: "txt", municipality: "21067"}).validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if _, err := readConfig(filepath.Join(t.TempDir(), "missing.json"), false); err != nil {
+		t.Errorf("expected a missing optional configuration to be ignored, got %v", err)
+	}
+	if err := os.WriteFile(filename, []byte(`{"fx_taxable": true}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readConfig(filename, true); err == nil {
+		t.Error("expected an unknown field to be an error")
+	}
+}
+
+func Test_adjust(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "adjustments.json")
+	content := `[
+  {"type": "isin", "symbol": "XYZ", "isin": "IE00B4L5Y983"},
+  {"type": "removeTrade", "isin": "US0378331005", "date": "2023-03-01", "quantity": -10,
+   "note": "Reported twice"},
+  {"type": "addTrade", "isin": "IE00B4L5Y983", "date": "2023-06-01", "quantity": 5, "price": 80, "currency": "usd"},
+  {"type": "returnOfCapital", "isin": "US0378331005", "date": "2023-05-16", "amount": 40},
+  {"type": "sourceCountry", "isin": "IE00B4L5Y983", "country": "us"},
+  {"type": "foreignTax", "isin": "US0378331005", "date": "2023-05-16", "amount": 9, "currency": "USD"},
+  {"type": "removeTrade", "isin": "US0378331005", "date": "2024-01-01", "quantity": 1}
+]`
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	adjs, sum, err := readAdjustments(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(adjs) != 7 || adjs[1].origin.Line != 3 || adjs[2].origin.Line != 5 || sum == "" {
+		t.Fatalf("unexpected adjustments: %+v", adjs)
+	}
+
+	day := func(m, d int) time.Time { return time.Date(2023, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	us := broker.ISIN("US037833100")
+	stmt := &broker.Statement{
+		Filename: "2023.csv",
+		Trades: []broker.Trade{
+			{Instrument: us, Time: day(3, 1).Add(14 * time.Hour), Quantity: -10, Price: 150},
+			{Instrument: us, Time: day(3, 1), Quantity: 10, Price: 100},
+		},
+		FixedIncome: []broker.Tx{{Instrument: us, Currency: "USD", Amount: 100, Year: 2023, Date: day(5, 16)}},
+		Prices:      []broker.Price{{Instrument: broker.Instrument{ID: "XYZ", Scheme: broker.SchemeSymbol}, Price: 90}},
+	}
+	stmts, diags := adjust([]*broker.Statement{stmt}, adjs, filename, sum)
+	if len(diags) != 1 || diags[0].Origin.Line != 9 {
+		t.Errorf("expected the last adjustment to match nothing, got %v", diags)
+	}
+	if len(stmts) != 2 || stmts[1].Broker != adjustmentsBroker || stmts[1].Checksum != sum {
+		t.Fatalf("expected a statement with the additions, got %+v", stmts)
+	}
+
+	ie := broker.ISIN("IE00B4L5Y98")
+	ie.Country = "US"
+	if len(stmt.Trades) != 1 || stmt.Trades[0].Quantity != 10 {
+		t.Errorf("expected the sale removed, got %+v", stmt.Trades)
+	}
+	if stmt.Prices[0].Instrument != ie {
+		t.Errorf("expected the symbol replaced with the ISIN and US source, got %+v", stmt.Prices[0].Instrument)
+	}
+	if added := stmts[1].Trades; len(added) != 1 || added[0].Instrument != ie || added[0].Currency != "USD" || added[0].Origin.Line != 5 {
+		t.Errorf("unexpected trades added: %+v", added)
+	}
+	if len(stmt.FixedIncome) != 1 || stmt.FixedIncome[0].Amount != 60 || len(stmt.ReturnOfCapital) != 1 || stmt.ReturnOfCapital[0].Amount != 40 {
+		t.Errorf("expected 40 of the dividend reclassified, got %+v and %+v", stmt.FixedIncome, stmt.ReturnOfCapital)
+	}
+	if tax := stmts[1].Tax; len(tax) != 1 || tax[0].Amount != -9 {
+		t.Errorf("unexpected tax added: %+v", tax)
+	}
+
+	l := &ledger{
+		profits:     []pl{{amount: 50, year: 2023, kind: capitalGain}},
+		deductible:  make(map[int]float64),
+		statements:  make(map[int]map[string]string),
+		adjustments: adjs,
+	}
+	var buf bytes.Buffer
+	r, _ := newReport(l, settings{})
+	if err := explain(&buf, r, 2023, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Ispravci izvoda", "Uklonjena transakcija US037833100 2023-03-01, količina -10 (Reported twice)", filename + ":3", "ISIN IE00B4L5Y98 za simbol XYZ"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("explanation is missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	if err := os.WriteFile(filename, []byte(`[{"type": "addTrade", "isin": "US0378331005", "date": "2023-01-01"}, {"type": "split"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readAdjustments(filename); err == nil || !strings.Contains(err.Error(), "quantity, price and currency required") || !strings.Contains(err.Error(), `unknown adjustment type "split"`) {
+		t.Errorf("expected invalid adjustments, got %v", err)
+	}
+}
+
+func Test_reduceBasis(t *testing.T) {
+	day := func(m, d int) time.Time { return time.Date(2023, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	us, de := broker.ISIN("US922908553"), broker.ISIN("DE000BASF11")
+	trades := []broker.Trade{
+		{Instrument: us, Time: day(1, 10), Currency: "USD", Quantity: 10, Price: 100},
+		{Instrument: us, Time: day(2, 10), Currency: "USD", Quantity: 10, Price: 110, Origin: broker.Origin{File: "2023.csv", Line: 8}},
+		{Instrument: us, Time: day(3, 10), Currency: "USD", Quantity: -15, Price: 120},
+		{Instrument: us, Time: day(5, 10), Currency: "USD", Quantity: -5, Price: 120},
+	}
+	rocs := []broker.Tx{
+		{Instrument: us, Currency: "USD", Amount: 20, Year: 2023, Date: day(4, 10)},
+		{Instrument: de, Currency: "EUR", Amount: 3, Year: 2023, Date: day(4, 10)},
+	}
+
+	reduced, unapplied := reduceBasis(trades, rocs, fixedRater(1))
+	if len(unapplied) != 1 || unapplied[0].Instrument != de {
+		t.Errorf("expected the payment with no lots held unapplied, got %+v", unapplied)
+	}
+	if len(reduced) != 5 || trades[1].Quantity != 10 || trades[1].Price != 110 {
+		t.Fatalf("expected the lot partly sold split, without changing the trades given: %+v", reduced)
+	}
+	if sold, held := reduced[1], reduced[2]; sold.Quantity != 5 || sold.Price != 110 || held.Quantity != 5 || held.Price != 106 || held.Origin != sold.Origin {
+		t.Errorf("expected 5 sold at the original cost and 5 held at a cost lowered by 4, got %+v and %+v", sold, held)
+	}
+
+	taxable, _, _ := fifo(reduced, fixedRater(1))
+	var profit float64
+	for _, p := range taxable {
+		profit += p.amount
+	}
+	// 10 × 20 + 5 × 10 + 5 × (120 - 106)
+	if math.Abs(profit-320) > 1e-9 {
+		t.Errorf("profit = %v; want 320", profit)
+	}
+}
+
+func Test_reduceBasis_manyTrades(t *testing.T) {
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	us := broker.ISIN("US922908553")
+	trades := []broker.Trade{
+		{Instrument: us, Time: day(2023, 1, 10), Currency: "USD", Quantity: 10, Price: 100},
+		{Instrument: us, Time: day(2023, 2, 10), Currency: "USD", Quantity: -5, Price: 100},
+		{Instrument: us, Time: day(2024, 4, 10), Currency: "USD", Quantity: -5, Price: 100},
+	}
+	rocs := []broker.Tx{{Instrument: us, Currency: "USD", Amount: 50, Year: 2024, Date: day(2024, 3, 10)}}
+	reduced, _ := reduceBasis(trades, rocs, fixedRater(1))
+
+	// Trades added out of order, as hypothetical sales are. With more than 12 trades, sorting is not done by insertion sort only
+	for i, d := range []int{15, 15, 14, 7, 16, 17, 8, 18, 9, 9} {
+		reduced = append(reduced, broker.Trade{Instrument: broker.ISIN(fmt.Sprintf("DE%09d", i)), Time: day(2023, 1, d), Currency: "EUR", Quantity: 1, Price: 10})
+	}
+	taxable, _, _ := fifo(reduced, fixedRater(1))
+	profits := make(map[int]float64)
+	for _, p := range taxable {
+		profits[p.year] += p.amount
+	}
+	// The part sold before the payment keeps its cost. The part held is lowered by 10 per unit
+	if profits[2023] != 0 || profits[2024] != 50 {
+		t.Errorf("profits = %v; want 0 in 2023 and 50 in 2024", profits)
+	}
+}
+
+// writeStatement writes a minimal IBKR activity statement of account U1234567 for a period such as "January 1, 2023 - December 31, 2023"
+// Trades of AAPL are given as date and time, quantity and price, e.g. "2023-03-01, 10:30:00",10,150
+func writeStatement(t *testing.T, dir, name, period string, trades ...string) string {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("Statement,Header,Field Name,Field Value\n")
+	b.WriteString("Statement,Data,BrokerName,Interactive Brokers Ireland Limited\n")
+	b.WriteString("Statement,Data,Period,\"" + period + "\"\n")
+	b.WriteString("Account Information,Header,Field Name,Field Value\n")
+	b.WriteString("Account Information,Data,Account,U1234567\n")
+	b.WriteString("Account Information,Data,Base Currency,EUR\n")
+	b.WriteString("Trades,Header,DataDiscriminator,Asset Category,Currency,Symbol,Date/Time,Quantity,T. Price,Comm/Fee\n")
+	for _, trade := range trades {
+		b.WriteString("Trades,Data,Order,Stocks,USD,AAPL," + trade + ",-1\n")
+	}
+	b.WriteString("Financial Instrument Information,Header,Asset Category,Symbol,Description,Conid,Security ID\n")
+	b.WriteString("Financial Instrument Information,Data,Stocks,AAPL,APPLE INC,265598,US0378331005\n")
+
+	filename := filepath.Join(dir, name)
+	if err := os.WriteFile(filename, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func readStatements(t *testing.T, files ...string) []*broker.Statement {
+	t.Helper()
+	var stmts []*broker.Statement
+	for _, file := range files {
+		stmt, err := ibkr.Read(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+func Test_deduplicate_read(t *testing.T) {
+	dir := t.TempDir()
+	stmts := readStatements(t,
+		writeStatement(t, dir, "2023.csv", "January 1, 2023 - December 31, 2023", `"2023-03-01, 10:30:00",10,150`, `"2023-09-01, 10:30:00",-4,170`),
+		writeStatement(t, dir, "2023-03.csv", "March 1, 2023 - March 31, 2023", `"2023-03-01, 10:30:00",10,150`),
+	)
+
+	kept, dropped, err := deduplicate(stmts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 1 || dropped[filepath.Join(dir, "2023-03.csv")] != filepath.Join(dir, "2023.csv") {
+		t.Errorf("expected the monthly statement covered by the yearly one, got %d statements and %v", len(kept), dropped)
+	}
+}
+
+func Test_coverageWarnings_read(t *testing.T) {
+	dir := t.TempDir()
+	stmts := readStatements(t,
+		writeStatement(t, dir, "2021.csv", "January 1, 2021 - December 31, 2021", `"2021-03-01, 10:30:00",5,100`),
+		writeStatement(t, dir, "2023.csv", "January 1, 2023 - December 31, 2023", `"2023-06-01, 10:30:00",-8,120`),
+	)
+
+	var trades []broker.Trade
+	for _, stmt := range stmts {
+		trades = append(trades, stmt.Trades...)
+	}
+	_, _, open := fifo(trades, fixedRater(1))
+	_, unmatched := splitOpen(open)
+
+	warnings := coverageWarnings(stmts, unmatched)
+	if len(warnings) != 2 {
+		t.Fatalf("expected a gap and a sale warning, got %v", warnings)
+	}
+	if !strings.Contains(warnings[0].Message, "No statements for account U1234567 from 2022-01-01 - 2022-12-31") {
+		t.Errorf("expected a gap in 2022, got %s", warnings[0])
+	}
+	if !strings.Contains(warnings[1].Message, "by 3") || !strings.Contains(warnings[1].Message, "2022-01-01 - 2022-12-31 is likely missing") {
+		t.Errorf("unexpected sale warning: %s", warnings[1])
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// reportWriter writes a report in a single output format
+type reportWriter interface {
+	write(w io.Writer, r report) error
+}
+
+// reportWriters maps output formats to their writers. Reports are written to report.<format>
+var reportWriters = map[string]reportWriter{
+	"txt":  textWriter{},
+	"json": jsonWriter{},
+	"csv":  csvWriter{},
+	"xlsx": xlsxWriter{},
+	"html": htmlWriter{},
+	"pdf":  pdfWriter{},
+}
+
+// writeReports writes the report in each of the requested formats
+func writeReports(r report, formats []string) error {
+	var errs []error
+	for _, format := range formats {
+		format = strings.TrimSpace(format)
+		rw, ok := reportWriters[format]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown report format %q", format))
+			continue
+		}
+		errs = append(errs, writeReport("report."+format, r, rw))
+	}
+	return errors.Join(errs...)
+}
+
+func writeReport(filename string, r report, rw reportWriter) (err error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if fErr := file.Close(); fErr != nil {
+			err = errors.Join(err, fErr)
+		}
+	}()
+
+	w := bufio.NewWriter(file)
+	defer func() {
+		if fErr := w.Flush(); fErr != nil {
+			err = errors.Join(err, fErr)
+		}
+	}()
+
+	return rw.write(w, r)
+}
+
+// textWriter writes the report rows padded to fixed width columns, for humans
+type textWriter struct{}
+
+func (textWriter) write(w io.Writer, r report) (err error) {
+	data := r.toRows()
+	// Calculate column widths
+	widths := colWidths(data)
+
+	for _, row := range data {
+		for i, cell := range row {
+			// Right-align 'Dobit' and tax amount columns
+			if i == 3 || i >= 5 {
+				cell = strings.Repeat(" ", widths[i]-len(cell)) + cell
+			}
+			_, err = fmt.Fprintf(w, "%-*s", widths[i]+2, cell)
+			if err != nil {
+				return
+			}
+		}
+		_, err = io.WriteString(w, "\n")
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func colWidths(data [][]string) []int {
+	widths := make([]int, len(data[0]))
+	for _, row := range data {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	return widths
+}
+
+// csvWriter writes the report rows as plain CSV
+type csvWriter struct{}
+
+func (csvWriter) write(w io.Writer, r report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(r.toRows()); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// jsonWriter writes the report as a list of tax years with typed fields
+type jsonWriter struct{}
+
+type jsonYear struct {
+	Year          int                    `json:"year"`
+	Currency      string                 `json:"currency"`
+	RealizedPL    float64                `json:"realizedPL"`
+	Income        map[string]float64     `json:"income"`
+	TaxDue        float64                `json:"taxDue"`
+	Deductible    float64                `json:"deductible"`
+	ForeignIncome map[string]jsonForeign `json:"foreignIncome"`
+	CurrencyGains map[string]float64     `json:"currencyGains"`
+	Statements    []string               `json:"statements"`
+}
+
+type jsonForeign struct {
+	Gains      float64 `json:"gains"`
+	TaxPaid    float64 `json:"taxPaid"`
+	Creditable float64 `json:"creditable"`
+	Excess     float64 `json:"excess"`
+	TaxDue     float64 `json:"taxDue"`
+}
+
+func (jsonWriter) write(w io.Writer, r report) error {
+	years := make([]jsonYear, 0, len(r))
+	for _, y := range r {
+		jy := jsonYear{
+			Year:          y.year,
+			Currency:      y.currency,
+			RealizedPL:    y.realizedPL,
+			Income:        y.income,
+			TaxDue:        y.taxDue,
+			Deductible:    y.deductible,
+			ForeignIncome: make(map[string]jsonForeign, len(y.foreignIncome)),
+			CurrencyGains: y.currencyGains,
+			Statements:    y.statements,
+		}
+		for source, f := range y.foreignIncome {
+			jy.ForeignIncome[source] = jsonForeign{Gains: f.gains, TaxPaid: f.taxPaid, Creditable: f.creditable, Excess: f.excess, TaxDue: f.taxDue}
+		}
+		years = append(years, jy)
+	}
+	sort.Slice(years, func(i, j int) bool {
+		return years[i].Year < years[j].Year
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(years)
+}
@@ -0,0 +1,306 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a minimal PDF document of A4 landscape pages with monospaced text, laid out top to bottom
+// Content flows to a new page when the current one is full
+type Document struct {
+	title string
+	pages []*bytes.Buffer
+	// y is the baseline of the last line on the current page
+	y float64
+}
+
+const (
+	pageWidth, pageHeight = 842.0, 595.0
+	margin                = 40.0
+	// charWidth is the width of a Courier character, relative to the font size
+	charWidth = 0.6
+	// gap is the number of characters between table columns
+	gap = 2
+)
+
+// Font sizes
+const (
+	headingSize    = 12.0
+	subheadingSize = 9.0
+	textSize       = 8.0
+	tableSize      = 7.0
+	footerSize     = 7.0
+)
+
+// Fonts, as named in the page resources
+const (
+	regular = "F1"
+	bold    = "F2"
+)
+
+// Column is a table column. Right aligned columns suit amounts
+type Column struct {
+	Title string
+	Right bool
+}
+
+// New creates an empty document. The title is shown in the footer of each page
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+// Heading adds a section heading
+func (d *Document) Heading(text string) {
+	d.space(headingSize)
+	d.line(bold, headingSize, margin, text)
+}
+
+// Subheading adds a heading within a section
+func (d *Document) Subheading(text string) {
+	d.space(subheadingSize / 2)
+	d.line(bold, subheadingSize, margin, text)
+}
+
+// Text adds a paragraph, wrapped to the page width
+func (d *Document) Text(text string) {
+	for _, line := range wrap(text, chars(textSize)) {
+		d.line(regular, textSize, margin, line)
+	}
+}
+
+// Table adds a table. Columns are as wide as their widest cell, truncated if the table does not fit the page
+// The header is repeated on each page the table spans
+func (d *Document) Table(columns []Column, rows [][]string) {
+	widths := make([]int, len(columns))
+	for i, c := range columns {
+		widths[i] = utf8.RuneCountInString(c.Title)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < len(widths) {
+				widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+			}
+		}
+	}
+	fit(widths, chars(tableSize))
+
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.Title
+	}
+	d.space(tableSize / 2)
+	d.row(bold, columns, widths, header)
+	for _, row := range rows {
+		if d.full(tableSize) {
+			d.newPage()
+			d.row(bold, columns, widths, header)
+		}
+		d.row(regular, columns, widths, row)
+	}
+}
+
+// Write writes the document as a PDF file
+func (d *Document) Write(w io.Writer) error {
+	if len(d.pages) == 0 {
+		d.newPage()
+	}
+
+	pw := &writer{w: w}
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-5 are fixed, followed by a page and its content for each page
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	pw.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	pw.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	pw.object(3, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	pw.object(4, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>")
+	pw.object(5, fmt.Sprintf("<< /Title (%s) /Producer (ibkr-report) >>", encode(d.title)))
+
+	for i, page := range d.pages {
+		// Page numbers are only known once all content is laid out
+		stream := bytes.NewBuffer(append([]byte(nil), page.Bytes()...))
+		footer := fmt.Sprintf("%s - %d/%d", d.title, i+1, len(d.pages))
+		text(stream, regular, footerSize, pageWidth-margin-width(footer, footerSize), margin/2, footer)
+
+		var content bytes.Buffer
+		zw := zlib.NewWriter(&content)
+		if _, err := zw.Write(stream.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		pw.object(6+2*i, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %g %g] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, regular, bold, 7+2*i))
+		pw.object(7+2*i, fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := pw.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1)
+	for _, off := range pw.offsets {
+		pw.printf("%010d 00000 n \n", off)
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets)+1, xref)
+	return pw.err
+}
+
+// writer writes PDF objects, keeping their offsets for the cross-reference table. Objects must be written in order
+type writer struct {
+	w       io.Writer
+	n       int
+	offsets []int
+	err     error
+}
+
+func (pw *writer) printf(format string, args ...any) {
+	if pw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(pw.w, format, args...)
+	pw.n += n
+	pw.err = err
+}
+
+func (pw *writer) object(id int, body string) {
+	pw.offsets = append(pw.offsets, pw.n)
+	pw.printf("%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (d *Document) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pageHeight - margin
+}
+
+// full reports if a line of text in the given size no longer fits the current page
+func (d *Document) full(size float64) bool {
+	return len(d.pages) == 0 || d.y-leading(size) < margin
+}
+
+// space adds vertical space before a block. Nothing is added at the top of a page
+func (d *Document) space(size float64) {
+	if d.full(size) || d.y == pageHeight-margin {
+		return
+	}
+	d.y -= size
+}
+
+func (d *Document) line(font string, size, x float64, s string) {
+	if d.full(size) {
+		d.newPage()
+	}
+	d.y -= leading(size)
+	text(d.pages[len(d.pages)-1], font, size, x, d.y, s)
+}
+
+func (d *Document) row(font string, columns []Column, widths []int, cells []string) {
+	var b strings.Builder
+	for i, w := range widths {
+		cell := ""
+		if i < len(cells) {
+			cell = truncate(cells[i], w)
+		}
+		pad := strings.Repeat(" ", w-utf8.RuneCountInString(cell))
+		if columns[i].Right {
+			b.WriteString(pad + cell)
+		} else {
+			b.WriteString(cell + pad)
+		}
+		b.WriteString(strings.Repeat(" ", gap))
+	}
+	d.line(font, tableSize, margin, strings.TrimRight(b.String(), " "))
+}
+
+func text(page *bytes.Buffer, font string, size, x, y float64, s string) {
+	fmt.Fprintf(page, "BT /%s %g Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, encode(s))
+}
+
+// leading is the line height for a font size
+func leading(size float64) float64 {
+	return size * 1.3
+}
+
+// chars is the number of characters in a font size fitting the page width
+func chars(size float64) int {
+	return int((pageWidth - 2*margin) / (size * charWidth))
+}
+
+func width(s string, size float64) float64 {
+	return float64(utf8.RuneCountInString(s)) * size * charWidth
+}
+
+// fit narrows the widest columns until the table fits in the available characters
+func fit(widths []int, available int) {
+	for {
+		total, widest := 0, 0
+		for i, w := range widths {
+			total += w + gap
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if total-gap <= available || widths[widest] <= 1 {
+			return
+		}
+		widths[widest]--
+	}
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+func wrap(s string, n int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= n:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	return append(lines, line)
+}
+
+// winAnsi maps characters outside Latin-1 to WinAnsiEncoding. Letters missing from it are replaced with their base letter
+var winAnsi = map[rune]byte{
+	'€': 0x80, '…': 0x85, '–': 0x96, '—': 0x97,
+	'Š': 0x8a, 'š': 0x9a, 'Ž': 0x8e, 'ž': 0x9e, 'Đ': 0xd0,
+	'Č': 'C', 'č': 'c', 'Ć': 'C', 'ć': 'c', 'đ': 'd',
+}
+
+// encode converts text to a WinAnsiEncoding PDF string literal, without the enclosing parentheses
+func encode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r))
+		default:
+			if c, ok := winAnsi[r]; ok {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('?')
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocument_Write(t *testing.T) {
+	doc := New("Izvješće")
+	doc.Heading("Sažetak")
+	doc.Text("Tečajevi HNB (srednji)")
+	rows := make([][]string, 200)
+	for i := range rows {
+		rows[i] = []string{strconv.Itoa(i), "US0378331005", "1234.56"}
+	}
+	doc.Table([]Column{{Title: "#"}, {Title: "ISIN"}, {Title: "Iznos", Right: true}}, rows)
+
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+
+	// 200 rows at 7pt do not fit a single page
+	count := regexp.MustCompile(`/Count (\d+)`).FindSubmatch(out)
+	if count == nil || string(count[1]) == "1" {
+		t.Fatalf("expected multiple pages, got %s", count)
+	}
+	pages, _ := strconv.Atoi(string(count[1]))
+
+	// Every cross-reference entry must point to its object
+	start := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(out)
+	if start == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(start[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point to the xref table", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 5+2*pages {
+		t.Fatalf("expected %d objects, got %d", 5+2*pages, len(entries))
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := fmt.Sprintf("%d 0 obj", i+1); !bytes.HasPrefix(out[off:], []byte(want)) {
+			t.Errorf("object %d offset %d points to %q", i+1, off, out[off:off+10])
+		}
+	}
+}
+
+func Test_encode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Dobit (EUR)", `Dobit \(EUR\)`},
+		{"Šž", "\x8a\x9e"},
+		{"Tečaj ćđ", "Tecaj cd"},
+		{"€ ü", "\x80 \xfc"},
+		{"日", "?"},
+	}
+
+	for _, tt := range tests {
+		if got := encode(tt.in); got != tt.want {
+			t.Errorf("encode(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
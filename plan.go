@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"ibkr-report/broker"
+	"ibkr-report/fx"
+	"ibkr-report/tax"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// saleOption is the sale of an open lot at its last known price
+type saleOption struct {
+	holding
+	// pl is the profit or loss of the sale. It is not taxable if the lot was held for more than 2 years
+	pl pl
+}
+
+// saleOptions simulates selling each open lot at its last known price on a date. Lots without a known price are skipped
+func saleOptions(holdings []holding, r fx.Rater, date time.Time) []saleOption {
+	var options []saleOption
+	for _, h := range holdings {
+		if h.price.Price == 0 {
+			continue
+		}
+		lot := h.lot
+		sale := broker.Trade{Instrument: lot.Instrument, Time: date, Currency: lot.Currency, Quantity: -lot.Quantity, Price: h.price.Price}
+		options = append(options, saleOption{holding: h, pl: profitFromTrades(&lot, &sale, r)})
+	}
+	return options
+}
+
+// harvest suggests lots to sell at a loss to offset the gains realized in a year
+// Only losses on lots held for less than 2 years are deductible. The largest are suggested first, until the gains are offset
+func harvest(options []saleOption, realized float64) []saleOption {
+	var losses []saleOption
+	for _, o := range options {
+		if o.pl.disposal.taxable && o.pl.amount < 0 {
+			losses = append(losses, o)
+		}
+	}
+	sort.Slice(losses, func(i, j int) bool {
+		return losses[i].pl.amount < losses[j].pl.amount
+	})
+
+	var picked []saleOption
+	for _, o := range losses {
+		if realized <= 0 {
+			break
+		}
+		picked = append(picked, o)
+		realized += o.pl.amount
+	}
+	return picked
+}
+
+// plan prints the effect of selling each open lot on the year's JOPPD profit, and suggests losses to realize to offset the gains
+func plan(w io.Writer, year int, realized float64, options []saleOption, rates tax.Rates) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	e := &explainer{w: tw}
+
+	e.printf("Plan prodaje %d (%s)\n", year, fx.Base(year))
+	e.printf("Ostvarena dobit\t%.2f\t\n\n", realized)
+
+	e.printf("Instrument\tKoličina\tDatum kupnje\tZadnja cijena\tDobit\tNeoporezivo od\tUčinak na JOPPD\t\n")
+	for _, o := range options {
+		effect := 0.0
+		if o.pl.disposal.taxable {
+			effect = math.Max(0, realized+o.pl.amount) - realized
+		}
+		e.printf("%s\t%s\t%s\t%.4f\t%.2f\t%s\t%.2f\t\n", o.lot.Instrument.ID, strconv.FormatFloat(o.lot.Quantity, 'f', -1, 64), o.lot.Time.Format("2006-01-02"),
+			o.price.Price, o.pl.amount, o.exempt.Format("2006-01-02"), effect)
+	}
+
+	picked := harvest(options, realized)
+	if len(picked) == 0 {
+		e.printf("\nNema gubitaka za prebijanje ostvarene dobiti\n")
+		return errors.Join(e.err, tw.Flush())
+	}
+
+	e.printf("\nPrijedlog: prodati prije izuzeća radi prebijanja dobiti\n")
+	after := realized
+	for _, o := range picked {
+		e.printf("%s\t%s\t%s\t%.4f\t%.2f\t%s\t\t\n", o.lot.Instrument.ID, strconv.FormatFloat(o.lot.Quantity, 'f', -1, 64), o.lot.Time.Format("2006-01-02"),
+			o.price.Price, o.pl.amount, o.exempt.Format("2006-01-02"))
+		after += o.pl.amount
+	}
+	after = math.Max(0, after)
+	e.printf("Dobit nakon prodaje\t%.2f\t\n", after)
+	e.printf("Ušteda poreza\t%.2f\t\n", rates.Due(realized)-rates.Due(after))
+
+	return errors.Join(e.err, tw.Flush())
+}
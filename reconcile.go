@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"ibkr-report/broker"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// reconciliation compares the profit or loss realized on an instrument in a statement's period, as reported by the broker
+// and as calculated from the matched trades
+type reconciliation struct {
+	file   string
+	symbol string
+	// currency is the currency the broker reports in, usually the base currency of the account
+	currency string
+	// reported is the broker's figure. matched is calculated from the sales in the statement and the lots matched to them, with commissions
+	reported, matched float64
+}
+
+func (r reconciliation) difference() float64 {
+	return r.matched - r.reported
+}
+
+// mismatch is true if the figures differ by more than exchange rates and rounding explain: 1 unit of the currency or 1% of the reported figure
+func (r reconciliation) mismatch() bool {
+	return math.Abs(r.difference()) > math.Max(1, math.Abs(r.reported)/100)
+}
+
+// reconcile compares the profits matched from the sales in each statement with the broker's realized profit per instrument
+// The broker includes commissions, so the commissions of the sale and of the matched part of the purchase are added
+// Sales in a currency other than the broker's are converted with the daily rates on the sale and purchase dates
+// Statements without the broker's figures are skipped
+func reconcile(stmts []*broker.Statement, l *ledger) []reconciliation {
+	commissions := make(map[broker.Origin]float64)
+	for _, stmt := range stmts {
+		for _, fee := range stmt.Fees {
+			commissions[fee.Origin] += fee.Amount
+		}
+	}
+	// Lots split when capital was returned keep the origin of the purchase, so their quantities add up
+	quantities := make(map[broker.Origin]float64)
+	for _, t := range l.trades {
+		quantities[t.Origin] += math.Abs(t.Quantity)
+	}
+	commission := func(origin broker.Origin, quantity float64) float64 {
+		if quantities[origin] == 0 {
+			return 0
+		}
+		return commissions[origin] * quantity / quantities[origin]
+	}
+
+	type key struct {
+		file       string
+		instrument broker.Instrument
+	}
+	sales := make(map[key][]pl)
+	for _, p := range append(append([]pl{}, l.profits...), l.exempt...) {
+		if p.disposal != nil && len(p.origins) > 0 {
+			k := key{p.origins[0].File, p.instrument}
+			sales[k] = append(sales[k], p)
+		}
+	}
+
+	var out []reconciliation
+	for _, stmt := range stmts {
+		if len(stmt.Realized) == 0 {
+			continue
+		}
+		currency := stmt.Realized[0].Currency
+		rate := func(from string, date time.Time) float64 {
+			if from == currency || currency == "" {
+				return 1
+			}
+			return l.rtr.RateOn(from, date) / l.rtr.RateOn(currency, date)
+		}
+		matched := func(instrument broker.Instrument) float64 {
+			var sum float64
+			for _, p := range sales[key{stmt.Filename, instrument}] {
+				d := p.disposal
+				sum += d.quantity*d.price*rate(p.currency, p.date) - d.quantity*d.cost*rate(p.currency, d.acquired)
+				sum += commission(p.origins[0], d.quantity)*rate(p.currency, p.date) + commission(d.lot, d.quantity)*rate(p.currency, d.acquired)
+			}
+			return sum
+		}
+
+		reported := make(map[broker.Instrument]int)
+		for _, r := range stmt.Realized {
+			if i, ok := reported[r.Instrument]; ok {
+				out[i].reported += r.Amount
+				continue
+			}
+			reported[r.Instrument] = len(out)
+			out = append(out, reconciliation{file: stmt.Filename, symbol: r.Symbol, currency: currency, reported: r.Amount, matched: matched(r.Instrument)})
+		}
+		// Sales the broker reports no profit for
+		for k := range sales {
+			if _, ok := reported[k.instrument]; ok || k.file != stmt.Filename {
+				continue
+			}
+			out = append(out, reconciliation{file: stmt.Filename, symbol: k.instrument.ID, currency: currency, matched: matched(k.instrument)})
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].file != out[j].file {
+			return out[i].file < out[j].file
+		}
+		return out[i].symbol < out[j].symbol
+	})
+	return out
+}
+
+// mismatches counts the instruments with figures differing from the broker's
+func mismatches(rs []reconciliation) int {
+	n := 0
+	for _, r := range rs {
+		if r.mismatch() {
+			n++
+		}
+	}
+	return n
+}
+
+// writeReconciliation prints the broker's and the calculated realized profit of each instrument, marking the ones to check
+func writeReconciliation(w io.Writer, rs []reconciliation) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	e := &explainer{w: tw}
+
+	e.printf("Izvod\tSimbol\tValuta\tBroker\tIzračunato\tRazlika\t\t\n")
+	for _, r := range rs {
+		check := ""
+		if r.mismatch() {
+			check = "Provjeriti"
+		}
+		e.printf("%s\t%s\t%s\t%.2f\t%.2f\t%.2f\t%s\t\n", r.file, r.symbol, r.currency, r.reported, r.matched, r.difference(), check)
+	}
+
+	if n := mismatches(rs); n > 0 {
+		e.printf("\nOstvarena dobit %d instrumenata odstupa od brokerove. Mogući uzroci su nedostajući izvodi, korporativne akcije ili izvršene opcije\n", n)
+	} else {
+		e.printf("\nOstvarena dobit svih instrumenata odgovara brokerovoj\n")
+	}
+	return errors.Join(e.err, tw.Flush())
+}
@@ -1,7 +1,6 @@
 package revolut
 
 import (
-	"fmt"
 	"ibkr-report/broker"
 	"strings"
 )
@@ -15,7 +14,7 @@ func Read(filename string) (*broker.Statement, error) {
 	}
 
 	// This is a placeholder for the actual implementation
-	fmt.Println("Reading Revolut statement: ", filename, "... Not implemented.")
-
-	return &broker.Statement{}, nil
+	return &broker.Statement{Broker: "Revolut", Filename: filename, Diagnostics: []broker.Diagnostic{
+		{Origin: broker.Origin{File: filename}, Severity: broker.Warning, Message: "Revolut statements are not supported yet, file skipped"},
+	}}, nil
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"ibkr-report/broker"
+	"ibkr-report/fx"
+	"math"
+	"sort"
+)
+
+// reduceBasis lowers the cost of the lots held when a return of capital was paid, by the amount paid per unit held
+// Lots partly sold before the payment are split, so that the part sold keeps its cost. A cost may go below zero if more capital
+// is returned than was paid, taxing the excess when the lot is sold
+// Payments in a currency other than the lot's are converted with the year's rates
+// It returns the adjusted trades, and the payments made when no lots were held
+func reduceBasis(trades []broker.Trade, rocs []broker.Tx, r fx.Rater) ([]broker.Trade, []broker.Tx) {
+	if len(rocs) == 0 {
+		return trades, nil
+	}
+	rocs = append([]broker.Tx{}, rocs...)
+	sort.SliceStable(rocs, func(i, j int) bool {
+		return rocs[i].Date.Before(rocs[j].Date)
+	})
+
+	grouped := tradesByInstrument(append([]broker.Trade{}, trades...))
+	var unapplied []broker.Tx
+	for _, roc := range rocs {
+		ts, ok := grouped[roc.Instrument]
+		if !ok {
+			unapplied = append(unapplied, roc)
+			continue
+		}
+
+		ts, held := splitHeld(ts, roc)
+		if len(held) == 0 {
+			unapplied = append(unapplied, roc)
+			continue
+		}
+		var units float64
+		for _, i := range held {
+			units += ts[i].Quantity
+		}
+		for _, i := range held {
+			t := &ts[i]
+			t.Price -= roc.Amount / units * r.Rate(roc.Currency, roc.Year) / r.Rate(t.Currency, roc.Year)
+		}
+		grouped[roc.Instrument] = ts
+	}
+
+	out := make([]broker.Trade, 0, len(trades))
+	for _, ts := range grouped {
+		out = append(out, ts...)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Time.Before(out[j].Time)
+	})
+	return out, unapplied
+}
+
+// splitHeld matches the sales before a payment to the purchases before it, first in first out, and splits the purchases partly sold
+// It returns the trades and the indexes of the purchases still held at the payment
+func splitHeld(ts []broker.Trade, roc broker.Tx) ([]broker.Trade, []int) {
+	left := make([]float64, 0, len(ts))
+	for _, t := range ts {
+		if !t.Time.Before(roc.Date) {
+			break
+		}
+		left = append(left, math.Max(0, t.Quantity))
+		sold := -t.Quantity
+		for i := 0; i < len(left)-1 && sold > 0; i++ {
+			used := math.Min(left[i], sold)
+			left[i] -= used
+			sold -= used
+		}
+	}
+
+	var held []int
+	for i := 0; i < len(left); i++ {
+		if left[i] < 1e-9 {
+			continue
+		}
+		if sold := ts[i].Quantity - left[i]; sold > 1e-9 {
+			// Keep the part sold as a lot of its own, before the part held
+			part := ts[i]
+			part.Quantity = sold
+			ts[i].Quantity = left[i]
+			ts = append(ts[:i], append([]broker.Trade{part}, ts[i:]...)...)
+			left = append(left[:i], append([]float64{0}, left[i:]...)...)
+			i++
+		}
+		held = append(held, i)
+	}
+	return ts, held
+}
@@ -0,0 +1,163 @@
+package tax
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//go:embed surtax.csv
+var surtaxTable string
+
+// treatyTable lists withholding tax rates on portfolio dividends from double taxation treaties, by source country
+//
+//go:embed treaties.csv
+var treatyTable string
+
+// Municipality is a Croatian city or municipality, identified by its tax administration code
+type Municipality struct {
+	Code, Name string
+}
+
+// surtax is a surtax (prirez) rate valid in a municipality for a range of years
+type surtax struct {
+	Municipality
+	from, to int
+	rate     float64
+}
+
+var surtaxes = mustParseSurtaxes(surtaxTable)
+
+var treaties = mustParseTreaties(treatyTable)
+
+// Rates are the tax rates applied to capital income in a single year
+type Rates struct {
+	// Income is the capital income tax rate
+	Income float64
+	// Surtax is the municipal surtax rate, applied to the income tax
+	Surtax float64
+}
+
+// Total returns the combined rate applied to taxable income
+func (r Rates) Total() float64 {
+	return r.Income * (1 + r.Surtax)
+}
+
+// Due returns the tax due on income. There is no tax due on losses
+func (r Rates) Due(income float64) float64 {
+	if income <= 0 {
+		return 0
+	}
+	return income * r.Total()
+}
+
+var ErrUnknownMunicipality = errors.New("unknown municipality")
+
+// ErrUnknownSurtax is returned for years the municipality's surtax rate is not known for
+var ErrUnknownSurtax = errors.New("unknown surtax rate")
+
+// For returns the capital income tax rates for a year, in a municipality identified by its code
+// Capital income was taxed at 12% until 2020 and 10% from 2021, with the municipal surtax on top.
+// The 2024 reform abolished the surtax. Municipalities now set income tax rates, but those apply only to employment and self-employment income.
+// Capital income is taxed at a flat 12% from 2024
+// An empty municipality code is accepted and results in no surtax
+// Surtax rates are known for the larger municipalities only. For other municipalities and years the rates are returned without
+// surtax, with an error, rather than guessing the rate
+func For(year int, municipality string) (Rates, error) {
+	switch {
+	case year >= 2024:
+		return Rates{Income: 0.12}, nil
+	case year >= 2021:
+		return withSurtax(Rates{Income: 0.10}, year, municipality)
+	default:
+		return withSurtax(Rates{Income: 0.12}, year, municipality)
+	}
+}
+
+func withSurtax(r Rates, year int, municipality string) (Rates, error) {
+	if municipality == "" {
+		return r, nil
+	}
+
+	for _, s := range surtaxes {
+		if s.Code == municipality && year >= s.from && year <= s.to {
+			r.Surtax = s.rate
+			return r, nil
+		}
+	}
+
+	m, err := Lookup(municipality)
+	if err != nil {
+		return r, err
+	}
+	return r, fmt.Errorf("%w for %s (%s) in %d", ErrUnknownSurtax, m.Name, m.Code, year)
+}
+
+// Lookup finds a municipality by its code
+func Lookup(code string) (Municipality, error) {
+	for _, s := range surtaxes {
+		if s.Code == code {
+			return s.Municipality, nil
+		}
+	}
+	return Municipality{}, fmt.Errorf("%w: %s", ErrUnknownMunicipality, code)
+}
+
+// TreatyRate returns the dividend withholding rate from the double taxation treaty with a source country
+// The second return value is false if there is no treaty with the country
+func TreatyRate(country string) (float64, bool) {
+	rate, ok := treaties[country]
+	return rate, ok
+}
+
+// Credit splits the Tax paid at a foreign source into the part creditable against Croatian Tax and the excess
+// The credit is limited to the treaty rate, if any, and to the Croatian Tax due on the same income
+// The excess over the treaty rate may be reclaimed at the source
+func Credit(country string, income, paid float64, r Rates) (creditable, excess float64) {
+	creditable = math.Min(paid, r.Due(income))
+	if rate, ok := TreatyRate(country); ok {
+		creditable = math.Min(creditable, income*rate)
+		excess = math.Max(0, paid-income*rate)
+	}
+	return creditable, excess
+}
+
+func mustParseTreaties(table string) map[string]float64 {
+	rows, err := csv.NewReader(strings.NewReader(table)).ReadAll()
+	if err != nil {
+		panic(err)
+	}
+
+	out := make(map[string]float64, len(rows))
+	for _, row := range rows[1:] {
+		rate, err := strconv.ParseFloat(row[1], 64)
+		if err != nil {
+			panic(fmt.Sprintf("invalid treaty table row %v: %v", row, err))
+		}
+		out[row[0]] = rate / 100
+	}
+	return out
+}
+
+func mustParseSurtaxes(table string) []surtax {
+	rows, err := csv.NewReader(strings.NewReader(table)).ReadAll()
+	if err != nil {
+		panic(err)
+	}
+
+	out := make([]surtax, 0, len(rows))
+	for _, row := range rows[1:] {
+		from, fErr := strconv.Atoi(row[2])
+		to, tErr := strconv.Atoi(row[3])
+		rate, rErr := strconv.ParseFloat(row[4], 64)
+		if err := errors.Join(fErr, tErr, rErr); err != nil {
+			panic(fmt.Sprintf("invalid surtax table row %v: %v", row, err))
+		}
+		out = append(out, surtax{Municipality: Municipality{Code: row[0], Name: row[1]}, from: from, to: to, rate: rate / 100})
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package tax
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestFor(t *testing.T) {
+	tests := []struct {
+		year         int
+		municipality string
+		total        float64
+		err          error
+	}{
+		{2020, "01333", 0.12 * 1.18, nil},
+		{2023, "01333", 0.10 * 1.18, nil},
+		// Rates unknown before the table starts are not guessed
+		{2016, "01333", 0.12, ErrUnknownSurtax},
+		{2024, "01333", 0.12, nil},
+		{2022, "", 0.10, nil},
+		{2022, "00000", 0.10, ErrUnknownMunicipality},
+		{2020, "03476", 0.12 * 1.14, nil},
+		{2021, "03476", 0.10 * 1.13, nil},
+	}
+
+	for _, tt := range tests {
+		rates, err := For(tt.year, tt.municipality)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("For(%d, %q) error = %v; want %v", tt.year, tt.municipality, err, tt.err)
+		}
+		if math.Abs(rates.Total()-tt.total) > 1e-9 {
+			t.Errorf("For(%d, %q) total rate = %v; want %v", tt.year, tt.municipality, rates.Total(), tt.total)
+		}
+	}
+}
+
+func TestCredit(t *testing.T) {
+	rates := Rates{Income: 0.10, Surtax: 0.18}
+	tests := []struct {
+		country            string
+		income, paid       float64
+		creditable, excess float64
+	}{
+		// DE withholds 26.375%. Treaty rate is 15%, Croatian Tax is 11.8%
+		{"DE", 1000, 263.75, 118, 113.75},
+		{"DE", 1000, 150, 118, 0},
+		{"SI", 1000, 150, 118, 0},
+		// No treaty, credit limited by Croatian Tax only
+		{"US", 1000, 300, 118, 0},
+		{"XX", 1000, 50, 50, 0},
+		// Treaty rate below Croatian Tax
+		{"CZ", 1000, 150, 100, 50},
+	}
+
+	for _, tt := range tests {
+		creditable, excess := Credit(tt.country, tt.income, tt.paid, rates)
+		if math.Abs(creditable-tt.creditable) > 1e-9 || math.Abs(excess-tt.excess) > 1e-9 {
+			t.Errorf("Credit(%q, %v, %v) = %v, %v; want %v, %v", tt.country, tt.income, tt.paid, creditable, excess, tt.creditable, tt.excess)
+		}
+	}
+}
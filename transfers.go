@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"ibkr-report/broker"
+	"ibkr-report/lots"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// transferDays is the most days a position may take to arrive at the receiving broker
+const transferDays = 14
+
+// transferPair is a position moved between accounts: its transfer out of one account and transfer in to another
+// Only IBKR statements have transfers so far, so pairs are between IBKR accounts. Positions from other brokers need opening lots
+// Lots of an instrument are matched across all accounts, so the transferred position keeps its original acquisition dates and cost
+type transferPair struct {
+	out, in broker.Transfer
+}
+
+// matchTransfers pairs transfers out with transfers in to another account, of the same instrument and quantity, arriving within transferDays
+// It returns the pairs and the transfers in and out left unmatched, ordered by time
+func matchTransfers(stmts []*broker.Statement) (pairs []transferPair, in, out []broker.Transfer) {
+	type accountTransfer struct {
+		broker.Transfer
+		account string
+	}
+	var ins, outs []accountTransfer
+	for _, stmt := range stmts {
+		for _, t := range stmt.Transfers {
+			at := accountTransfer{Transfer: t, account: stmt.Broker + " " + stmt.Account}
+			if t.Quantity > 0 {
+				ins = append(ins, at)
+			} else {
+				outs = append(outs, at)
+			}
+		}
+	}
+	for _, ts := range [][]accountTransfer{ins, outs} {
+		sort.SliceStable(ts, func(i, j int) bool {
+			return ts[i].Time.Before(ts[j].Time)
+		})
+	}
+
+	matched := make([]bool, len(ins))
+	for _, o := range outs {
+		found := false
+		for i, t := range ins {
+			if matched[i] || t.account == o.account || t.Instrument != o.Instrument || math.Abs(t.Quantity+o.Quantity) > 1e-9 {
+				continue
+			}
+			if t.Time.Before(o.Time) || t.Time.Sub(o.Time) > transferDays*24*time.Hour {
+				continue
+			}
+			matched[i], found = true, true
+			pairs = append(pairs, transferPair{out: o.Transfer, in: t.Transfer})
+			break
+		}
+		if !found {
+			out = append(out, o.Transfer)
+		}
+	}
+	for i, t := range ins {
+		if !matched[i] {
+			in = append(in, t.Transfer)
+		}
+	}
+	return pairs, in, out
+}
+
+// transferWarnings warns about positions transferred without the other side in the statements
+// Positions transferred in without opening lots have no cost and acquisition date, so sales of them would exceed known holdings.
+// Each warning shows the opening lots row to add
+// Positions transferred out stay in the holdings, and their later sales are missing
+func transferWarnings(stmts []*broker.Statement) []broker.Diagnostic {
+	_, in, out := matchTransfers(stmts)
+
+	opening := make(map[broker.Instrument]float64)
+	for _, stmt := range stmts {
+		if stmt.Broker == lots.Broker {
+			for _, t := range stmt.Trades {
+				opening[t.Instrument] += t.Quantity
+			}
+		}
+	}
+
+	var warnings []broker.Diagnostic
+	for _, t := range in {
+		// Opening lots cover transfers in order, until their quantity is used up
+		covered := math.Min(opening[t.Instrument], t.Quantity)
+		opening[t.Instrument] -= covered
+		if missing := t.Quantity - covered; missing > 0 {
+			qty := strconv.FormatFloat(missing, 'f', -1, 64)
+			warnings = append(warnings, broker.Diagnostic{Origin: t.Origin, Section: "Transfers", Severity: broker.Warning, Message: fmt.Sprintf(
+				"Transfer of %s %s on %s has no cost basis. Add the statements of the sending account, or add it to an opening lots file as: %s,<acquisition date>,%s,<total cost>,<currency>",
+				qty, t.Instrument.ID, t.Time.Format("2006-01-02"), t.Instrument.ID, qty)})
+		}
+	}
+	for _, t := range out {
+		warnings = append(warnings, broker.Diagnostic{Origin: t.Origin, Section: "Transfers", Severity: broker.Warning, Message: fmt.Sprintf(
+			"Transfer of %s %s out on %s has no matching transfer in. Add the statements of the receiving account, or its sales are missing from the report",
+			strconv.FormatFloat(-t.Quantity, 'f', -1, 64), t.Instrument.ID, t.Time.Format("2006-01-02"))})
+	}
+	return warnings
+}
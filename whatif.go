@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"ibkr-report/broker"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// hypotheticalSale is a sale entered by the user to see its effect on the report, without touching the statements
+type hypotheticalSale struct {
+	// id is the ISIN or symbol of the instrument
+	id       string
+	quantity float64
+	date     time.Time
+	// price is per unit, in the currency the instrument was bought in
+	price float64
+}
+
+// parseSale reads a hypothetical sale from its fields: ISIN, quantity, date (YYYY-MM-DD) and price
+func parseSale(fields []string) (hypotheticalSale, error) {
+	if len(fields) != 4 {
+		return hypotheticalSale{}, fmt.Errorf("expected ISIN, quantity, date and price, got %q", strings.Join(fields, ","))
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	qty, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || qty <= 0 {
+		return hypotheticalSale{}, fmt.Errorf("invalid quantity %q", fields[1])
+	}
+	date, err := time.Parse("2006-01-02", fields[2])
+	if err != nil {
+		return hypotheticalSale{}, fmt.Errorf("invalid date %q", fields[2])
+	}
+	price, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil || price < 0 {
+		return hypotheticalSale{}, fmt.Errorf("invalid price %q", fields[3])
+	}
+
+	return hypotheticalSale{id: strings.ToUpper(fields[0]), quantity: qty, date: date, price: price}, nil
+}
+
+// readSales reads hypothetical sales from a csv file, one per row. A header row is skipped
+func readSales(filename string) ([]hypotheticalSale, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var sales []hypotheticalSale
+	var errs []error
+	for i, row := range rows {
+		if i == 0 && len(row) > 1 {
+			if _, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64); err != nil {
+				continue
+			}
+		}
+		s, err := parseSale(row)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", filename, i+1, err))
+			continue
+		}
+		sales = append(sales, s)
+	}
+	return sales, errors.Join(errs...)
+}
+
+// syntheticTrades creates sale trades for the hypothetical sales, in the instrument and currency of the purchases they are matched to
+// ISINs are matched with or without the check digit
+func (l *ledger) syntheticTrades(sales []hypotheticalSale) ([]broker.Trade, error) {
+	var trades []broker.Trade
+	var errs []error
+	for _, s := range sales {
+		purchase, ok := l.purchaseOf(s.id)
+		if !ok {
+			errs = append(errs, fmt.Errorf("no purchases of %s", s.id))
+			continue
+		}
+		trades = append(trades, broker.Trade{
+			Instrument: purchase.Instrument,
+			Category:   purchase.Category,
+			Time:       s.date,
+			Currency:   purchase.Currency,
+			Quantity:   -s.quantity,
+			Price:      s.price,
+			Origin:     broker.Origin{File: "what-if"},
+		})
+	}
+	return trades, errors.Join(errs...)
+}
+
+func (l *ledger) purchaseOf(id string) (broker.Trade, bool) {
+	for _, t := range l.trades {
+		if t.Quantity > 0 && (t.Instrument.ID == id || (len(id) == 12 && t.Instrument.ID == id[:11])) {
+			return t, true
+		}
+	}
+	return broker.Trade{}, false
+}
+
+// withTrades returns a copy of the ledger with additional trades, matching all trades again
+// Profits from other sources are kept as they are. Profits outside the periods of tax residence are dropped, as in the ledger
+func (l *ledger) withTrades(ts []broker.Trade) *ledger {
+	c := *l
+	c.trades = append(append([]broker.Trade{}, l.trades...), ts...)
+
+	c.profits = nil
+	for _, p := range l.profits {
+		if p.disposal == nil {
+			c.profits = append(c.profits, p)
+		}
+	}
+	taxable, exempt, open := fifo(append([]broker.Trade{}, c.trades...), c.rtr)
+	c.profits = append(c.profits, taxable...)
+	c.exempt = exempt
+	c.open, c.unmatched = splitOpen(open)
+	c.withResidence(l.residence)
+	return &c
+}
+
+// compareReports prints the report figures changed by hypothetical sales in the given years, with the total Tax left to pay
+func compareReports(w io.Writer, before, after report, years []int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	e := &explainer{w: tw}
+	e.printf("Godina\tIzvješće\tIzvor prihoda\tPrije\tPoslije\tRazlika\t\n")
+
+	row := func(year int, label, source string, b, a float64) {
+		if math.Abs(a-b) < 0.005 && label != "Porez za uplatu" {
+			return
+		}
+		e.printf("%d\t%s\t%s\t%.2f\t%.2f\t%.2f\t\n", year, label, source, b, a, a-b)
+	}
+	for _, year := range years {
+		b, a := before[year], after[year]
+		row(year, "JOPPD", "", b.profit(), a.profit())
+		sources := make(map[string]struct{})
+		for _, y := range []*taxYear{b, a} {
+			if y == nil {
+				continue
+			}
+			for source := range y.foreignIncome {
+				sources[source] = struct{}{}
+			}
+		}
+		for _, source := range sortedKeys(sources) {
+			row(year, "INO-DOH", source, b.foreignGains(source), a.foreignGains(source))
+		}
+		row(year, "Porez za uplatu", "", b.totalTaxDue(), a.totalTaxDue())
+	}
+
+	return errors.Join(e.err, tw.Flush())
+}
+
+// profit returns the JOPPD profit of a year. A year missing from a report has none
+func (y *taxYear) profit() float64 {
+	if y == nil {
+		return 0
+	}
+	return y.realizedPL
+}
+
+func (y *taxYear) foreignGains(source string) float64 {
+	if y == nil || y.foreignIncome[source] == nil {
+		return 0
+	}
+	return y.foreignIncome[source].gains
+}
+
+// totalTaxDue returns the Tax left to pay on the JOPPD profit and on all foreign income
+func (y *taxYear) totalTaxDue() float64 {
+	if y == nil {
+		return 0
+	}
+	due := y.taxDue
+	for _, f := range y.foreignIncome {
+		due += f.taxDue
+	}
+	return due
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"ibkr-report/xlsx"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// kindNames are the Croatian names of income kinds
+var kindNames = map[string]string{capitalGain: "Kapitalni dobitak", dividend: "Dividenda", interest: "Kamata"}
+
+// xlsxWriter writes the report as a workbook with a summary sheet and detail sheets tracing every figure in it
+type xlsxWriter struct{}
+
+func (xlsxWriter) write(w io.Writer, r report) error {
+	wb := xlsx.New()
+
+	summary := wb.AddSheet("Sažetak")
+	for i, row := range r.toRows() {
+		cells := make([]any, len(row))
+		for j, cell := range row {
+			cells[j] = cell
+			// Keep numbers as numbers, except for the header
+			if n, err := strconv.ParseFloat(cell, 64); err == nil && i > 0 && j >= 3 && j != 4 {
+				cells[j] = n
+			}
+		}
+		summary.AddRow(cells...)
+	}
+
+	disposals := wb.AddSheet("Prodaje")
+	disposals.AddRow("Godina", "Instrument", "Količina", "Datum kupnje", "Datum prodaje", "Nabavna cijena", "Prodajna cijena", "Valuta", "Tečaj", "Dobit u valuti", "Dobit", "Oporezivo")
+	payments := wb.AddSheet("Dividende i kamate")
+	payments.AddRow("Godina", "Datum", "Vrsta", "Instrument", "Izvor prihoda", "Valuta", "Iznos u valuti", "Tečaj", "Iznos")
+	taxes := wb.AddSheet("Porez po odbitku")
+	taxes.AddRow("Godina", "Datum", "Instrument", "Izvor prihoda", "Valuta", "Iznos u valuti", "Tečaj", "Iznos")
+	fees := wb.AddSheet("Naknade")
+	fees.AddRow("Godina", "Valuta", "Iznos u valuti", "Tečaj", "Iznos")
+
+	years := make([]int, 0, len(r))
+	for year := range r {
+		years = append(years, year)
+	}
+	sort.Ints(years)
+
+	for _, yr := range years {
+		d := r[yr].details
+		for _, p := range d.disposals {
+			taxable := "Da"
+			if !p.disposal.taxable {
+				taxable = "Ne"
+			}
+			disposals.AddRow(yr, p.instrument.ID, xlsx.Precise(p.disposal.quantity), p.disposal.acquired, p.date, p.disposal.cost, p.disposal.price, p.currency, xlsx.Precise(p.rate), p.original, p.amount, taxable)
+		}
+		for _, p := range d.payments {
+			payments.AddRow(yr, p.date, kindNames[p.kind], p.instrument.ID, p.source, p.currency, p.original, xlsx.Precise(p.rate), p.amount)
+		}
+		for _, p := range d.taxes {
+			taxes.AddRow(yr, p.date, p.instrument.ID, p.source, p.currency, math.Abs(p.original), xlsx.Precise(p.rate), math.Abs(p.amount))
+		}
+		for _, p := range d.fees {
+			fees.AddRow(yr, p.currency, math.Abs(p.original), xlsx.Precise(p.rate), math.Abs(p.amount))
+		}
+	}
+
+	return wb.Write(w)
+}
@@ -0,0 +1,73 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkbook_Write(t *testing.T) {
+	wb := New()
+	s := wb.AddSheet("Prodaje & dividende")
+	s.AddRow("Godina", "Iznos", "Datum")
+	s.AddRow(2023, 1234.5, time.Date(2023, 5, 31, 0, 0, 0, 0, time.UTC), Precise(0.926784))
+	s.AddRow(nil, "<escaped>")
+
+	var buf bytes.Buffer
+	if err := wb.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		files[f.Name] = string(bs)
+
+		// Every part must be well-formed XML
+		dec := xml.NewDecoder(bytes.NewReader(bs))
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed: %v", f.Name, err)
+			}
+		}
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+
+	sheet := files["xl/worksheets/sheet1.xml"]
+	for _, want := range []string{`<c r="A2"><v>2023</v></c>`, `<c r="B2" s="2"><v>1234.5</v></c>`, `<c r="C2" s="1"><v>45077</v></c>`, `<c r="D2" s="3"><v>0.926784</v></c>`, `&lt;escaped&gt;`} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("sheet missing %s", want)
+		}
+	}
+	if strings.Contains(sheet, `r="A3"`) {
+		t.Error("nil cells should be left empty")
+	}
+}
+
+func Test_column(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := column(i); got != want {
+			t.Errorf("column(%d) = %s; want %s", i, got, want)
+		}
+	}
+}